@@ -0,0 +1,273 @@
+package concpatterns
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Submit when a bounded queue has no room left.
+var ErrQueueFull = errors.New("queue full")
+
+// waitBuckets are the upper bounds of the queue wait histogram.
+var waitBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	math.MaxInt64,
+}
+
+// AutoPoolConfig configures an AutoPool. Zero values get sane defaults.
+type AutoPoolConfig struct {
+	MinWorkers int
+	MaxWorkers int // defaults to max(MinWorkers, 1)
+
+	// a worker is added whenever the oldest queued task has waited longer
+	// than TargetWait
+	TargetWait time.Duration
+	// workers above MinWorkers exit after idling for IdleTimeout
+	IdleTimeout time.Duration
+
+	// 0 means unbounded
+	QueueSize int
+
+	// nil means the real clock
+	Clock Clock
+}
+
+// WaitBucket is one bucket of the queue wait histogram: how many tasks waited
+// at most Le. The last bucket has Le set to the max duration.
+type WaitBucket struct {
+	Le    time.Duration
+	Count uint64
+}
+
+// AutoPoolStats is a point in time view of an AutoPool.
+type AutoPoolStats struct {
+	Workers       int
+	Idle          int
+	QueueDepth    int
+	WaitHistogram []WaitBucket
+}
+
+type autoTask struct {
+	fn       func()
+	enqueued time.Time
+}
+
+// AutoPool is Pooling without the fixed runtime.NumCPU() size: it grows while
+// tasks sit in the queue for too long and shrinks back when workers go idle.
+type AutoPool struct {
+	cfg AutoPoolConfig
+
+	mu      sync.Mutex
+	queue   []autoTask
+	workers int
+	idle    int
+	hist    []uint64
+	closed  bool
+
+	// a single token: a worker that takes a task passes it on if there's more
+	notify chan struct{}
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewAutoPool starts MinWorkers workers and the scaling monitor.
+func NewAutoPool(cfg AutoPoolConfig) *AutoPool {
+	cfg.MinWorkers = max(cfg.MinWorkers, 0)
+	cfg.MaxWorkers = max(cfg.MaxWorkers, cfg.MinWorkers, 1)
+	if cfg.TargetWait <= 0 {
+		cfg.TargetWait = 10 * time.Millisecond
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 10 * time.Second
+	}
+	cfg.Clock = orReal(cfg.Clock)
+
+	p := &AutoPool{
+		cfg:    cfg,
+		hist:   make([]uint64, len(waitBuckets)),
+		notify: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	for range cfg.MinWorkers {
+		p.spawn()
+	}
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.monitor()
+	}()
+
+	return p
+}
+
+// Submit queues fn to run on one of the workers.
+func (p *AutoPool) Submit(fn func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if p.cfg.QueueSize > 0 && len(p.queue) >= p.cfg.QueueSize {
+		return ErrQueueFull
+	}
+
+	p.queue = append(p.queue, autoTask{fn: fn, enqueued: p.cfg.Clock.Now()})
+	// with MinWorkers 0 there may be nobody around to pick it up
+	if p.workers == 0 {
+		p.spawn()
+	}
+	p.wake()
+	return nil
+}
+
+// Stats returns the current size, queue depth and wait histogram.
+func (p *AutoPool) Stats() AutoPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hist := make([]WaitBucket, len(waitBuckets))
+	for i, le := range waitBuckets {
+		hist[i] = WaitBucket{Le: le, Count: p.hist[i]}
+	}
+
+	return AutoPoolStats{
+		Workers:       p.workers,
+		Idle:          p.idle,
+		QueueDepth:    len(p.queue),
+		WaitHistogram: hist,
+	}
+}
+
+// Close stops accepting tasks. Queued tasks still run.
+func (p *AutoPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		close(p.quit)
+	}
+}
+
+// Wait blocks until every worker has exited after Close.
+func (p *AutoPool) Wait() {
+	p.wg.Wait()
+}
+
+// spawn starts a worker. Must be called with mu held.
+func (p *AutoPool) spawn() {
+	p.workers++
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.work()
+	}()
+}
+
+// wake hands the notify token to a waiting worker, if it isn't already out.
+func (p *AutoPool) wake() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (p *AutoPool) work() {
+	idle := p.cfg.Clock.NewTimer(p.cfg.IdleTimeout)
+	defer idle.Stop()
+
+	for {
+		p.mu.Lock()
+		if len(p.queue) > 0 {
+			t := p.queue[0]
+			p.queue[0] = autoTask{}
+			p.queue = p.queue[1:]
+			p.observe(p.cfg.Clock.Since(t.enqueued))
+			if len(p.queue) > 0 {
+				p.wake()
+			}
+			p.mu.Unlock()
+
+			t.fn()
+			continue
+		}
+		if p.closed {
+			p.workers--
+			p.mu.Unlock()
+			return
+		}
+		p.idle++
+		p.mu.Unlock()
+
+		if !idle.Stop() {
+			select {
+			case <-idle.C():
+			default:
+			}
+		}
+		idle.Reset(p.cfg.IdleTimeout)
+
+		retire := false
+		select {
+		case <-p.notify:
+		case <-p.quit:
+		case <-idle.C():
+			retire = true
+		}
+
+		p.mu.Lock()
+		p.idle--
+		if retire && p.workers > p.cfg.MinWorkers && len(p.queue) == 0 {
+			p.workers--
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+}
+
+// observe records a queue wait. Must be called with mu held.
+func (p *AutoPool) observe(d time.Duration) {
+	for i, le := range waitBuckets {
+		if d <= le {
+			p.hist[i]++
+			return
+		}
+	}
+}
+
+// monitor adds a worker whenever the head of the queue has waited past the
+// target, checking twice per TargetWait.
+func (p *AutoPool) monitor() {
+	ticker := p.cfg.Clock.NewTicker(max(p.cfg.TargetWait/2, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			p.mu.Lock()
+			if len(p.queue) > 0 && p.workers < p.cfg.MaxWorkers &&
+				p.cfg.Clock.Since(p.queue[0].enqueued) > p.cfg.TargetWait {
+				p.spawn()
+			}
+			p.mu.Unlock()
+
+		case <-p.quit:
+			return
+		}
+	}
+}
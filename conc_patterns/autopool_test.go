@@ -0,0 +1,138 @@
+package concpatterns
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+// advanceUntil moves fc on a step at a time, letting the pool's goroutines
+// catch up in between, until cond holds.
+func advanceUntil(t *testing.T, fc *FakeClock, step time.Duration, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; !cond(); i++ {
+		if i == 1000 {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		fc.Advance(step)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAutoPoolScales(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	p := NewAutoPool(AutoPoolConfig{
+		MinWorkers:  1,
+		MaxWorkers:  3,
+		TargetWait:  10 * time.Millisecond,
+		IdleTimeout: time.Minute,
+		Clock:       fc,
+	})
+
+	release := make(chan struct{})
+	for range 4 {
+		if err := p.Submit(func() { <-release }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the worker to take a task", func() bool { return p.Stats().QueueDepth == 3 })
+	workers := func(n int) func() bool {
+		return func() bool { return p.Stats().Workers == n }
+	}
+
+	// one worker busy, three tasks queued: nothing happens until the head of
+	// the queue has waited out TargetWait
+	advanceUntil(t, fc, 5*time.Millisecond, "the pool to grow", workers(2))
+	if waited := fc.Since(epoch); waited <= 10*time.Millisecond {
+		t.Fatalf("grew after %v, before TargetWait", waited)
+	}
+	advanceUntil(t, fc, 5*time.Millisecond, "the pool to grow", workers(3))
+
+	// and never past MaxWorkers
+	fc.Advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if st := p.Stats(); st.Workers != 3 || st.QueueDepth != 1 {
+		t.Fatalf("stats = %+v, want 3 workers and 1 queued", st)
+	}
+
+	close(release)
+	waitFor(t, "the workers to go idle", func() bool {
+		st := p.Stats()
+		return st.Idle == 3 && st.QueueDepth == 0
+	})
+	fc.Advance(time.Minute - time.Nanosecond)
+	time.Sleep(10 * time.Millisecond)
+	if n := p.Stats().Workers; n != 3 {
+		t.Fatalf("%d workers before IdleTimeout, want 3", n)
+	}
+
+	// back down to MinWorkers once they've idled for IdleTimeout
+	fc.Advance(time.Nanosecond)
+	waitFor(t, "the pool to shrink", workers(1))
+
+	st := p.Stats()
+	var total, slow uint64
+	for _, b := range st.WaitHistogram {
+		total += b.Count
+		if b.Le > 10*time.Millisecond {
+			slow += b.Count
+		}
+	}
+	// the first task was picked up straight away, the rest waited past target
+	if total != 4 || st.WaitHistogram[0].Count != 1 || slow != 3 {
+		t.Fatalf("wait histogram = %+v", st.WaitHistogram)
+	}
+
+	p.Close()
+	p.Wait()
+	if err := p.Submit(func() {}); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("Submit after Close = %v, want ErrPoolClosed", err)
+	}
+}
+
+func TestAutoPoolQueueFull(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	p := NewAutoPool(AutoPoolConfig{
+		MinWorkers: 1,
+		MaxWorkers: 1,
+		QueueSize:  1,
+		Clock:      NewFakeClock(epoch),
+	})
+
+	release := make(chan struct{})
+	block := func() { <-release }
+	p.Submit(block)
+	waitFor(t, "the worker to take the task", func() bool { return p.Stats().QueueDepth == 0 })
+
+	if err := p.Submit(block); err != nil {
+		t.Fatalf("Submit with room = %v", err)
+	}
+	if err := p.Submit(block); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit on a full queue = %v, want ErrQueueFull", err)
+	}
+
+	close(release)
+	p.Close()
+	p.Wait()
+}
+
+func TestAutoPoolFromZero(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	p := NewAutoPool(AutoPoolConfig{Clock: NewFakeClock(epoch)})
+	if n := p.Stats().Workers; n != 0 {
+		t.Fatalf("%d workers with MinWorkers 0", n)
+	}
+
+	ran := make(chan struct{})
+	p.Submit(func() { close(ran) })
+	<-ran
+
+	p.Close()
+	p.Wait()
+}
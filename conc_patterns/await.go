@@ -0,0 +1,257 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNothingToAwait is returned when an Await func is given no attempts.
+var ErrNothingToAwait = errors.New("await: no funcs given")
+
+// TimeoutError is returned by AwaitTimeout when the result didn't come in time.
+type TimeoutError struct {
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("await: no result after %s", e.After)
+}
+
+// Timeout makes TimeoutError match net.Error style checks.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Unwrap lets errors.Is(err, context.DeadlineExceeded) match.
+func (e *TimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// AllFailedError is returned by AwaitFirst when every attempt failed.
+type AllFailedError struct {
+	Errs []error
+}
+
+func (e *AllFailedError) Error() string {
+	return fmt.Sprintf("await: all %d attempts failed: %v", len(e.Errs), errors.Join(e.Errs...))
+}
+
+func (e *AllFailedError) Unwrap() []error { return e.Errs }
+
+// QuorumError is returned by AwaitQuorum once enough attempts have failed that
+// the quorum can no longer be reached.
+type QuorumError struct {
+	Need int
+	Got  int
+	Errs []error
+}
+
+func (e *QuorumError) Error() string {
+	return fmt.Sprintf("await: quorum of %d not reached, got %d: %v", e.Need, e.Got, errors.Join(e.Errs...))
+}
+
+func (e *QuorumError) Unwrap() []error { return e.Errs }
+
+// RetryError is returned by Retry once it runs out of attempts.
+type RetryError struct {
+	Attempts int
+	Last     error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("await: gave up after %d attempts: %v", e.Attempts, e.Last)
+}
+
+func (e *RetryError) Unwrap() error { return e.Last }
+
+type awaitResult[T any] struct {
+	v   T
+	err error
+}
+
+// AwaitTimeout is WaitForResult with a deadline d, measured on c (nil means
+// the real clock). fn gets a ctx that's cancelled once we stop waiting; the
+// result channel is buffered so fn can still finish afterwards without leaking
+// (see Cancellation).
+func AwaitTimeout[T any](ctx context.Context, c Clock, d time.Duration, fn func(context.Context) (T, error)) (T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan awaitResult[T], 1)
+	go func() {
+		v, err := fn(ctx)
+		ch <- awaitResult[T]{v: v, err: err}
+	}()
+
+	t := orReal(c).NewTimer(d)
+	defer t.Stop()
+
+	var zero T
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-t.C():
+		return zero, &TimeoutError{After: d}
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// AwaitFirst returns the first successful result of fns, launched one after
+// another: the next one starts when hedge passes without a result, or right
+// away when one of the running ones fails. hedge <= 0 starts them all at
+// once. The losers are cancelled. hedge is measured on c, nil meaning the real
+// clock.
+func AwaitFirst[T any](ctx context.Context, c Clock, hedge time.Duration, fns ...func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if len(fns) == 0 {
+		return zero, ErrNothingToAwait
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan awaitResult[T], len(fns))
+	launched := 0
+	launch := func() {
+		fn := fns[launched]
+		launched++
+		go func() {
+			v, err := fn(ctx)
+			ch <- awaitResult[T]{v: v, err: err}
+		}()
+	}
+
+	if hedge <= 0 {
+		for launched < len(fns) {
+			launch()
+		}
+	} else {
+		launch()
+	}
+
+	var hedgeC <-chan time.Time
+	var t Timer
+	if launched < len(fns) {
+		t = orReal(c).NewTimer(hedge)
+		defer t.Stop()
+		hedgeC = t.C()
+	}
+
+	var errs []error
+	for {
+		select {
+		case r := <-ch:
+			if r.err == nil {
+				return r.v, nil
+			}
+			errs = append(errs, r.err)
+			if len(errs) == len(fns) {
+				return zero, &AllFailedError{Errs: errs}
+			}
+			// don't sit out the hedge delay to replace one we know has failed
+			if launched < len(fns) {
+				launch()
+				// the timer may have fired unread, and a stale tick would
+				// launch the next one straight away
+				if !t.Stop() {
+					select {
+					case <-t.C():
+					default:
+					}
+				}
+				t.Reset(hedge)
+			}
+
+		case <-hedgeC:
+			if launched < len(fns) {
+				launch()
+			}
+			if launched < len(fns) {
+				t.Reset(hedge)
+			}
+
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// AwaitQuorum runs all fns at once and returns as soon as k of them succeed,
+// cancelling the rest. Results are in the order they came in.
+func AwaitQuorum[T any](ctx context.Context, k int, fns ...func(context.Context) (T, error)) ([]T, error) {
+	if len(fns) == 0 || k <= 0 {
+		return nil, ErrNothingToAwait
+	}
+	if k > len(fns) {
+		return nil, &QuorumError{Need: k}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan awaitResult[T], len(fns))
+	for _, fn := range fns {
+		go func() {
+			v, err := fn(ctx)
+			ch <- awaitResult[T]{v: v, err: err}
+		}()
+	}
+
+	var (
+		got  []T
+		errs []error
+	)
+	for {
+		select {
+		case r := <-ch:
+			if r.err != nil {
+				errs = append(errs, r.err)
+				if len(fns)-len(errs) < k {
+					return got, &QuorumError{Need: k, Got: len(got), Errs: errs}
+				}
+				continue
+			}
+			got = append(got, r.v)
+			if len(got) == k {
+				return got, nil
+			}
+
+		case <-ctx.Done():
+			return got, ctx.Err()
+		}
+	}
+}
+
+// RetryPolicy configures Retry. Zero values get sane defaults.
+type RetryPolicy struct {
+	MaxAttempts int // 0 means retry until ctx is done
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	Clock       Clock // nil means the real one
+}
+
+// Retry calls fn until it succeeds, backing off exponentially between attempts.
+func Retry[T any](ctx context.Context, policy RetryPolicy, fn func(context.Context) (T, error)) (T, error) {
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff < policy.MinBackoff {
+		policy.MaxBackoff = max(10*time.Second, policy.MinBackoff)
+	}
+
+	var zero T
+	backoff := policy.MinBackoff
+	for attempt := 1; ; attempt++ {
+		v, err := fn(ctx)
+		if err == nil {
+			return v, nil
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return zero, &RetryError{Attempts: attempt, Last: err}
+		}
+
+		if !sleepCtx(ctx, orReal(policy.Clock), backoff) {
+			return zero, ctx.Err()
+		}
+		backoff = min(backoff*2, policy.MaxBackoff)
+	}
+}
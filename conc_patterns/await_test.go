@@ -0,0 +1,132 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAwaitTimeout(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	cancelled := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := AwaitTimeout(context.Background(), fc, time.Second, func(ctx context.Context) (int, error) {
+			<-ctx.Done()
+			close(cancelled)
+			return 0, ctx.Err()
+		})
+		done <- err
+	}()
+
+	fc.BlockUntil(1)
+	fc.Advance(time.Second)
+
+	err := <-done
+	var te *TimeoutError
+	if !errors.As(err, &te) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want a TimeoutError", err)
+	}
+	<-cancelled
+}
+
+func TestAwaitFirstHedges(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	slow := func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	fast := func(context.Context) (string, error) { return "hedge", nil }
+
+	done := make(chan string, 1)
+	go func() {
+		v, _ := AwaitFirst(context.Background(), fc, time.Second, slow, fast)
+		done <- v
+	}()
+
+	// the hedge only goes out once the delay passes
+	fc.BlockUntil(1)
+	select {
+	case v := <-done:
+		t.Fatalf("returned %q before the hedge delay", v)
+	default:
+	}
+	fc.Advance(time.Second)
+
+	if v := <-done; v != "hedge" {
+		t.Fatalf("got %q, want the hedged result", v)
+	}
+}
+
+func TestAwaitFirstFailureLaunchesNext(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	fail := func(context.Context) (int, error) { return 0, errors.New("down") }
+	ok := func(context.Context) (int, error) { return 2, nil }
+
+	// no Advance: a failure mustn't wait out the hedge delay
+	v, err := AwaitFirst(context.Background(), fc, time.Hour, fail, ok)
+	if err != nil || v != 2 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+
+	_, err = AwaitFirst(context.Background(), fc, time.Hour, fail, fail)
+	var all *AllFailedError
+	if !errors.As(err, &all) || len(all.Errs) != 2 {
+		t.Fatalf("err = %v, want both failures", err)
+	}
+}
+
+func TestAwaitQuorum(t *testing.T) {
+	val := func(v int) func(context.Context) (int, error) {
+		return func(context.Context) (int, error) { return v, nil }
+	}
+	fail := func(context.Context) (int, error) { return 0, errors.New("down") }
+
+	got, err := AwaitQuorum(context.Background(), 2, fail, val(1), val(1))
+	if err != nil || len(got) != 2 {
+		t.Fatalf("got %v, %v", got, err)
+	}
+
+	_, err = AwaitQuorum(context.Background(), 2, fail, fail, val(1))
+	var qe *QuorumError
+	if !errors.As(err, &qe) || qe.Need != 2 {
+		t.Fatalf("err = %v, want a QuorumError", err)
+	}
+}
+
+func TestRetryBacksOff(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		_, err := Retry(context.Background(), RetryPolicy{
+			MinBackoff: time.Second,
+			MaxBackoff: time.Minute,
+			Clock:      fc,
+		}, func(context.Context) (int, error) {
+			if calls++; calls < 3 {
+				return 0, errors.New("not yet")
+			}
+			return calls, nil
+		})
+		done <- err
+	}()
+
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second} {
+		fc.BlockUntil(1)
+		fc.Advance(backoff - time.Millisecond)
+		if fc.Pending() != 1 {
+			t.Fatalf("retried before the %v backoff", backoff)
+		}
+		fc.Advance(time.Millisecond)
+	}
+
+	if err := <-done; err != nil || calls != 3 {
+		t.Fatalf("err = %v after %d calls", err, calls)
+	}
+}
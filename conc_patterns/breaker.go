@@ -0,0 +1,334 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen   = errors.New("circuit breaker is open")
+	ErrTooManyTrials = errors.New("circuit breaker is half-open: trial limit reached")
+)
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through while counting failures.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every call straight away until OpenTimeout passes.
+	BreakerOpen
+	// BreakerHalfOpen lets a few trial calls through to see if the dependency
+	// is back.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// BreakerConfig configures a CircuitBreaker. Zero values get sane defaults.
+type BreakerConfig struct {
+	// failures are counted over a rolling Window split into Buckets
+	Window  time.Duration
+	Buckets int
+
+	// the breaker opens once at least MinRequests calls in the window have
+	// failed at a rate of FailureRatio or more
+	MinRequests  int
+	FailureRatio float64
+
+	// how long to stay open before letting trial calls through
+	OpenTimeout time.Duration
+
+	// trial calls allowed while half-open; that many successes close the
+	// breaker, a single failure opens it again
+	HalfOpenTrials int
+
+	// IsFailure decides which errors count against the dependency, the rest
+	// count as successes. The default counts every error. A call given up on
+	// by cancelling its ctx counts as neither, whatever IsFailure says.
+	IsFailure func(error) bool
+
+	// OnStateChange, if set, is called on every transition. It runs outside
+	// the breaker's lock, so it may call back into the breaker.
+	OnStateChange func(from, to BreakerState)
+
+	// nil means the real clock
+	Clock Clock
+}
+
+// callOutcome is what a finished call tells the breaker about the dependency.
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	// the caller gave up, so the call says nothing either way
+	callIgnored
+)
+
+type breakerBucket struct {
+	slot      int64
+	successes int
+	failures  int
+}
+
+// CircuitBreaker stops calls to a failing dependency for a while instead of
+// hammering it, then probes it with a few trial calls before letting traffic
+// back in.
+type CircuitBreaker struct {
+	cfg   BreakerConfig
+	width time.Duration
+	// bucket slots count widths since start, which keeps them non-negative
+	// whatever the clock reads
+	start time.Time
+
+	mu         sync.Mutex
+	state      BreakerState
+	generation uint64
+	buckets    []breakerBucket
+	openedAt   time.Time
+	trials     int
+	trialOK    int
+}
+
+// NewCircuitBreaker creates a closed breaker.
+func NewCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.Buckets <= 0 {
+		cfg.Buckets = 10
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 5 * time.Second
+	}
+	if cfg.HalfOpenTrials <= 0 {
+		cfg.HalfOpenTrials = 1
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(err error) bool { return err != nil }
+	}
+	cfg.Clock = orReal(cfg.Clock)
+
+	return &CircuitBreaker{
+		cfg:     cfg,
+		width:   max(cfg.Window/time.Duration(cfg.Buckets), 1),
+		start:   cfg.Clock.Now(),
+		buckets: make([]breakerBucket, cfg.Buckets),
+	}
+}
+
+// State returns the current state.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	var changes []func()
+	cb.tick(cb.cfg.Clock.Now(), &changes)
+	state := cb.state
+	cb.mu.Unlock()
+
+	cb.notify(changes)
+	return state
+}
+
+// Do calls fn unless the breaker is open, and records how it went. When the
+// breaker refuses the call fn isn't run and ErrCircuitOpen or
+// ErrTooManyTrials is returned.
+func (cb *CircuitBreaker) Do(ctx context.Context, fn func(context.Context) error) (err error) {
+	gen, err := cb.before()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// a panic counts as a failure and keeps going up
+		if r := recover(); r != nil {
+			cb.after(gen, callFailed)
+			panic(r)
+		}
+		cb.after(gen, cb.outcome(ctx, err))
+	}()
+
+	return fn(ctx)
+}
+
+// BreakerFunc wraps fn so every call goes through cb. It fits NewPool,
+// ParallelMap and pipeline stages as is.
+func BreakerFunc[In, Out any](cb *CircuitBreaker, fn func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, in In) (Out, error) {
+		var out Out
+		err := cb.Do(ctx, func(ctx context.Context) error {
+			var err error
+			out, err = fn(ctx, in)
+			return err
+		})
+		return out, err
+	}
+}
+
+func (cb *CircuitBreaker) before() (uint64, error) {
+	cb.mu.Lock()
+	var changes []func()
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(changes)
+	}()
+
+	cb.tick(cb.cfg.Clock.Now(), &changes)
+
+	switch cb.state {
+	case BreakerOpen:
+		return 0, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if cb.trials >= cb.cfg.HalfOpenTrials {
+			return 0, ErrTooManyTrials
+		}
+		cb.trials++
+	}
+	return cb.generation, nil
+}
+
+// outcome sorts a call that returned err under ctx.
+func (cb *CircuitBreaker) outcome(ctx context.Context, err error) callOutcome {
+	switch {
+	case err == nil:
+		return callSucceeded
+	case errors.Is(err, context.Canceled) && errors.Is(ctx.Err(), context.Canceled):
+		return callIgnored
+	case cb.cfg.IsFailure(err):
+		return callFailed
+	default:
+		return callSucceeded
+	}
+}
+
+func (cb *CircuitBreaker) after(gen uint64, outcome callOutcome) {
+	cb.mu.Lock()
+	var changes []func()
+	defer func() {
+		cb.mu.Unlock()
+		cb.notify(changes)
+	}()
+
+	now := cb.cfg.Clock.Now()
+	cb.tick(now, &changes)
+
+	// the result of a call made in an earlier state says nothing about now
+	if gen != cb.generation {
+		return
+	}
+
+	if outcome == callIgnored {
+		// hand the trial slot back for someone who'll see it through
+		if cb.state == BreakerHalfOpen {
+			cb.trials--
+		}
+		return
+	}
+	failed := outcome == callFailed
+
+	switch cb.state {
+	case BreakerClosed:
+		b := cb.bucket(now)
+		if failed {
+			b.failures++
+		} else {
+			b.successes++
+		}
+
+		total, failures := cb.counts(now)
+		if total >= cb.cfg.MinRequests && float64(failures)/float64(total) >= cb.cfg.FailureRatio {
+			cb.setState(BreakerOpen, now, &changes)
+		}
+
+	case BreakerHalfOpen:
+		if failed {
+			cb.setState(BreakerOpen, now, &changes)
+			return
+		}
+		cb.trialOK++
+		if cb.trialOK >= cb.cfg.HalfOpenTrials {
+			cb.setState(BreakerClosed, now, &changes)
+		}
+	}
+}
+
+// tick moves an open breaker to half-open once OpenTimeout has passed. Must be
+// called with mu held.
+func (cb *CircuitBreaker) tick(now time.Time, changes *[]func()) {
+	if cb.state == BreakerOpen && now.Sub(cb.openedAt) >= cb.cfg.OpenTimeout {
+		cb.setState(BreakerHalfOpen, now, changes)
+	}
+}
+
+// setState switches state and queues the hook. Must be called with mu held.
+func (cb *CircuitBreaker) setState(to BreakerState, now time.Time, changes *[]func()) {
+	from := cb.state
+	if from == to {
+		return
+	}
+
+	cb.state = to
+	cb.generation++
+	cb.trials, cb.trialOK = 0, 0
+
+	switch to {
+	case BreakerOpen:
+		cb.openedAt = now
+	case BreakerClosed:
+		clear(cb.buckets)
+	}
+
+	if hook := cb.cfg.OnStateChange; hook != nil {
+		*changes = append(*changes, func() { hook(from, to) })
+	}
+}
+
+func (cb *CircuitBreaker) notify(changes []func()) {
+	for _, fn := range changes {
+		fn()
+	}
+}
+
+// bucket returns the bucket for now, recycling it if it's left over from an
+// earlier pass around the ring. Must be called with mu held.
+func (cb *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	slot := int64(now.Sub(cb.start) / cb.width)
+	b := &cb.buckets[slot%int64(len(cb.buckets))]
+	if b.slot != slot {
+		*b = breakerBucket{slot: slot}
+	}
+	return b
+}
+
+// counts sums up the buckets still inside the window. Must be called with mu
+// held.
+func (cb *CircuitBreaker) counts(now time.Time) (total, failures int) {
+	slot := int64(now.Sub(cb.start) / cb.width)
+	for _, b := range cb.buckets {
+		if slot-b.slot < int64(len(cb.buckets)) {
+			total += b.successes + b.failures
+			failures += b.failures
+		}
+	}
+	return total, failures
+}
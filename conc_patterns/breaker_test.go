@@ -0,0 +1,234 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+var errDep = errors.New("dependency down")
+
+func newTestBreaker(fc *FakeClock, transitions *[]string) *CircuitBreaker {
+	return NewCircuitBreaker(BreakerConfig{
+		Window:       10 * time.Second,
+		Buckets:      10,
+		MinRequests:  4,
+		FailureRatio: 0.5,
+		OpenTimeout:  5 * time.Second,
+		Clock:        fc,
+		OnStateChange: func(from, to BreakerState) {
+			*transitions = append(*transitions, from.String()+"->"+to.String())
+		},
+	})
+}
+
+func call(cb *CircuitBreaker, err error) (ran bool, got error) {
+	got = cb.Do(context.Background(), func(context.Context) error {
+		ran = true
+		return err
+	})
+	return ran, got
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	var transitions []string
+	cb := newTestBreaker(fc, &transitions)
+
+	// 1 in 3 failing is under the ratio, and 3 calls is under MinRequests anyway
+	call(cb, nil)
+	call(cb, nil)
+	call(cb, errDep)
+	if s := cb.State(); s != BreakerClosed {
+		t.Fatalf("state = %v after 1/3 failures, want closed", s)
+	}
+
+	// 2 in 4 hits the ratio
+	call(cb, errDep)
+	if s := cb.State(); s != BreakerOpen {
+		t.Fatalf("state = %v after 2/4 failures, want open", s)
+	}
+
+	if ran, err := call(cb, nil); ran || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open breaker: ran = %v, err = %v; want fail fast with ErrCircuitOpen", ran, err)
+	}
+
+	fc.Advance(5*time.Second - time.Nanosecond)
+	if s := cb.State(); s != BreakerOpen {
+		t.Fatalf("state = %v before OpenTimeout, want open", s)
+	}
+	fc.Advance(time.Nanosecond)
+	if s := cb.State(); s != BreakerHalfOpen {
+		t.Fatalf("state = %v after OpenTimeout, want half-open", s)
+	}
+
+	if ran, err := call(cb, nil); !ran || err != nil {
+		t.Fatalf("trial call: ran = %v, err = %v", ran, err)
+	}
+	if s := cb.State(); s != BreakerClosed {
+		t.Fatalf("state = %v after a good trial, want closed", s)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if !slices.Equal(transitions, want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	var transitions []string
+	cb := newTestBreaker(fc, &transitions)
+
+	for range 4 {
+		call(cb, errDep)
+	}
+	fc.Advance(5 * time.Second)
+
+	// only one trial at a time; a second caller is turned away while it runs
+	err := cb.Do(context.Background(), func(context.Context) error {
+		if _, err := call(cb, nil); !errors.Is(err, ErrTooManyTrials) {
+			t.Errorf("second trial: err = %v, want ErrTooManyTrials", err)
+		}
+		return errDep
+	})
+	if !errors.Is(err, errDep) {
+		t.Fatalf("trial returned %v", err)
+	}
+
+	// and the failed trial opens it again for a full OpenTimeout
+	if s := cb.State(); s != BreakerOpen {
+		t.Fatalf("state = %v after a failed trial, want open", s)
+	}
+	fc.Advance(time.Second)
+	if _, err := call(cb, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	var transitions []string
+	cb := newTestBreaker(fc, &transitions)
+
+	call(cb, errDep)
+	call(cb, errDep)
+	call(cb, errDep)
+
+	// those failures roll out of the window before the fourth call
+	fc.Advance(11 * time.Second)
+	call(cb, errDep)
+	if s := cb.State(); s != BreakerClosed {
+		t.Fatalf("state = %v with old failures out of the window, want closed", s)
+	}
+}
+
+// cancelled makes a call the caller gives up on part way through.
+func cancelled(cb *CircuitBreaker) (ran bool, got error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	got = cb.Do(ctx, func(ctx context.Context) error {
+		ran = true
+		cancel()
+		return ctx.Err()
+	})
+	return ran, got
+}
+
+func TestBreakerIgnoresCancellation(t *testing.T) {
+	t.Run("closed", func(t *testing.T) {
+		fc := NewFakeClock(epoch)
+		var transitions []string
+		cb := newTestBreaker(fc, &transitions)
+
+		for range 10 {
+			cancelled(cb)
+		}
+		if s := cb.State(); s != BreakerClosed {
+			t.Fatalf("state = %v after cancelled calls, want closed", s)
+		}
+
+		// and they don't water down the failures either: 2 in 4 still opens
+		call(cb, nil)
+		call(cb, nil)
+		call(cb, errDep)
+		call(cb, errDep)
+		if s := cb.State(); s != BreakerOpen {
+			t.Fatalf("state = %v after 2/4 failures, want open", s)
+		}
+	})
+
+	t.Run("half-open", func(t *testing.T) {
+		fc := NewFakeClock(epoch)
+		var transitions []string
+		cb := newTestBreaker(fc, &transitions)
+
+		for range 4 {
+			call(cb, errDep)
+		}
+		fc.Advance(5 * time.Second)
+
+		// a cancelled trial neither closes the breaker nor uses up the trial
+		if ran, _ := cancelled(cb); !ran {
+			t.Fatal("trial call didn't run")
+		}
+		if s := cb.State(); s != BreakerHalfOpen {
+			t.Fatalf("state = %v after a cancelled trial, want half-open", s)
+		}
+		if ran, err := call(cb, errDep); !ran || !errors.Is(err, errDep) {
+			t.Fatalf("next trial: ran = %v, err = %v", ran, err)
+		}
+		if s := cb.State(); s != BreakerOpen {
+			t.Fatalf("state = %v after a failed trial, want open", s)
+		}
+	})
+
+	// context.Canceled from the dependency, with the caller still waiting,
+	// is just another error
+	t.Run("not the caller's", func(t *testing.T) {
+		fc := NewFakeClock(epoch)
+		var transitions []string
+		cb := newTestBreaker(fc, &transitions)
+
+		for range 4 {
+			call(cb, context.Canceled)
+		}
+		if s := cb.State(); s != BreakerOpen {
+			t.Fatalf("state = %v, want open", s)
+		}
+	})
+}
+
+func TestBreakerBeforeEpoch(t *testing.T) {
+	fc := NewFakeClock(time.Time{})
+	var transitions []string
+	cb := newTestBreaker(fc, &transitions)
+
+	for range 4 {
+		call(cb, errDep)
+	}
+	if s := cb.State(); s != BreakerOpen {
+		t.Fatalf("state = %v, want open", s)
+	}
+}
+
+func TestBreakerPanicCountsAsFailure(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	var transitions []string
+	cb := newTestBreaker(fc, &transitions)
+
+	for range 4 {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("panic was swallowed")
+				}
+			}()
+			_ = cb.Do(context.Background(), func(context.Context) error { panic("boom") })
+		}()
+	}
+	if s := cb.State(); s != BreakerOpen {
+		t.Fatalf("state = %v after 4 panics, want open", s)
+	}
+}
@@ -0,0 +1,342 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the slice of the time package the patterns use, so tests can swap
+// in a FakeClock and run without real waiting.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a *time.Timer behind an interface.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a *time.Ticker behind an interface.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// demo holds the clock the demo funcs in patterns.go tell time with, see
+// SetClock. Everything else takes a Clock of its own.
+var demo atomic.Pointer[clockRef]
+
+type clockRef struct{ Clock }
+
+func init() { demo.Store(&clockRef{RealClock()}) }
+
+// demoClock returns the clock the demo funcs run on. They read it once on the
+// way in, so a run sticks to one clock throughout.
+func demoClock() Clock { return demo.Load().Clock }
+
+// SetClock swaps the clock the demo funcs in patterns.go run on and returns a
+// func restoring the previous one. Demos already running carry on with the
+// clock they started with. Types and funcs outside the demos take a Clock in
+// their config, options or arguments instead.
+func SetClock(c Clock) (restore func()) {
+	prev := demo.Swap(&clockRef{c})
+	return func() { demo.Store(prev) }
+}
+
+// orReal returns c, or the real clock if c is nil.
+func orReal(c Clock) Clock {
+	if c == nil {
+		return RealClock()
+	}
+	return c
+}
+
+// withTimeout is context.WithTimeout with d measured on c: once d passes on c,
+// Err is context.DeadlineExceeded for the ctx and everything derived from it.
+func withTimeout(ctx context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+
+	inner, cancel := context.WithCancelCause(ctx)
+	cc := &clockCtx{
+		Context:  inner,
+		deadline: c.Now().Add(d),
+		done:     make(chan struct{}),
+	}
+	if pd, ok := ctx.Deadline(); ok && pd.Before(cc.deadline) {
+		cc.deadline = pd
+	}
+
+	t := c.AfterFunc(d, func() {
+		cancel(context.DeadlineExceeded)
+		cc.closeDone()
+	})
+	// the parent being cancelled reaches inner first, then done
+	context.AfterFunc(inner, func() {
+		t.Stop()
+		cc.closeDone()
+	})
+	return cc, func() {
+		t.Stop()
+		cancel(context.Canceled)
+		cc.closeDone()
+	}
+}
+
+// withDeadline is context.WithDeadline with t read on c.
+func withDeadline(ctx context.Context, c Clock, t time.Time) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithDeadline(ctx, t)
+	}
+	return withTimeout(ctx, c, t.Sub(c.Now()))
+}
+
+// clockCtx is the ctx withTimeout returns off the real clock. It's a cancel
+// ctx whose Err says DeadlineExceeded when that's the cause. Done is its own
+// channel, so ctxs derived from it can't hook into the cancel ctx underneath
+// and inherit its context.Canceled; they go through Err instead.
+type clockCtx struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (c *clockCtx) closeDone() { c.once.Do(func() { close(c.done) }) }
+
+func (c *clockCtx) Deadline() (time.Time, bool) { return c.deadline, true }
+func (c *clockCtx) Done() <-chan struct{}       { return c.done }
+
+func (c *clockCtx) Err() error {
+	select {
+	case <-c.done:
+	default:
+		return nil
+	}
+	if errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
+
+// RealClock returns a Clock backed by the time package.
+func RealClock() Clock { return realClock{} }
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (r realTimer) C() <-chan time.Time        { return r.t.C }
+func (r realTimer) Stop() bool                 { return r.t.Stop() }
+func (r realTimer) Reset(d time.Duration) bool { return r.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time   { return r.t.C }
+func (r realTicker) Stop()                 { r.t.Stop() }
+func (r realTicker) Reset(d time.Duration) { r.t.Reset(d) }
+
+// FakeClock is a Clock that only moves when told to. Timers, tickers and
+// sleepers fire as Advance passes their deadline, in deadline order.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration // tickers only
+	ch     chan time.Time
+	fn     func() // AfterFunc only
+}
+
+// NewFakeClock creates a fake clock reading start.
+func NewFakeClock(start time.Time) *FakeClock {
+	f := &FakeClock{now: start}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *FakeClock) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *FakeClock) Sleep(d time.Duration) {
+	<-f.NewTimer(d).C()
+}
+
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *FakeClock) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.add(d, 0, fn)
+}
+
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0, nil)
+}
+
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("fake clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d, nil)}
+}
+
+func (f *FakeClock) add(d, period time.Duration, fn func()) *fakeWaiter {
+	w := &fakeWaiter{
+		clock:  f,
+		period: period,
+		ch:     make(chan time.Time, 1),
+		fn:     fn,
+	}
+
+	f.mu.Lock()
+	w.at = f.now.Add(d)
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+	f.mu.Unlock()
+
+	// a timer that's already due fires straight away, as it would for real
+	if d <= 0 {
+		f.Advance(0)
+	}
+	return w
+}
+
+// Advance moves the clock forward by d, firing everything that comes due on
+// the way. AfterFunc funcs run on their own goroutine, like the real ones.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	target := f.now.Add(d)
+	for {
+		var next *fakeWaiter
+		for _, w := range f.waiters {
+			if !w.at.After(target) && (next == nil || w.at.Before(next.at)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		f.now = next.at
+		switch {
+		case next.fn != nil:
+			go next.fn()
+		default:
+			// like the real ones, a tick nobody's reading is dropped
+			select {
+			case next.ch <- f.now:
+			default:
+			}
+		}
+
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			f.remove(next)
+		}
+	}
+	f.now = target
+}
+
+// Pending returns how many timers, tickers and sleepers are waiting.
+func (f *FakeClock) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until at least n timers, tickers or sleepers are waiting,
+// which is how a test knows the code under it has got to its next wait.
+func (f *FakeClock) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// remove drops w from the waiters and reports whether it was there. Must be
+// called with mu held.
+func (f *FakeClock) remove(w *fakeWaiter) bool {
+	for i, x := range f.waiters {
+		if x == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.ch }
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	f := w.clock
+
+	f.mu.Lock()
+	active := f.remove(w)
+	w.at = f.now.Add(d)
+	if w.period > 0 {
+		w.period = d
+	}
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+	f.mu.Unlock()
+
+	if d <= 0 {
+		f.Advance(0)
+	}
+	return active
+}
+
+type fakeTicker struct{ w *fakeWaiter }
+
+func (t fakeTicker) C() <-chan time.Time   { return t.w.ch }
+func (t fakeTicker) Stop()                 { t.w.Stop() }
+func (t fakeTicker) Reset(d time.Duration) { t.w.Reset(d) }
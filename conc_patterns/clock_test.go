@@ -0,0 +1,254 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fired reports whether ch has a value ready.
+func fired(ch <-chan time.Time) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeClockAdvance(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	short := fc.NewTimer(time.Second)
+	long := fc.NewTimer(3 * time.Second)
+
+	fc.Advance(999 * time.Millisecond)
+	if fired(short.C()) {
+		t.Fatal("timer fired early")
+	}
+
+	fc.Advance(time.Millisecond)
+	select {
+	case at := <-short.C():
+		if want := epoch.Add(time.Second); !at.Equal(want) {
+			t.Fatalf("fired at %v, want %v", at, want)
+		}
+	default:
+		t.Fatal("timer didn't fire on its deadline")
+	}
+	if fired(long.C()) {
+		t.Fatal("later timer fired too")
+	}
+
+	fc.Advance(time.Hour)
+	if !fired(long.C()) {
+		t.Fatal("later timer didn't fire")
+	}
+	if got := fc.Since(epoch); got != time.Hour+time.Second {
+		t.Fatalf("Since(start) = %v", got)
+	}
+	if fc.Pending() != 0 {
+		t.Fatalf("Pending() = %d after everything fired", fc.Pending())
+	}
+}
+
+func TestFakeClockAfterFuncOrder(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	got := make(chan int, 3)
+	for _, d := range []int{3, 1, 2} {
+		fc.AfterFunc(time.Duration(d)*time.Second, func() { got <- d })
+		// AfterFuncs run on their own goroutines, so fire one at a time to
+		// check the order
+	}
+	for want := 1; want <= 3; want++ {
+		fc.Advance(time.Second)
+		if d := <-got; d != want {
+			t.Fatalf("fired %d, want %d", d, want)
+		}
+	}
+}
+
+func TestFakeClockStopReset(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	tm := fc.NewTimer(time.Second)
+	if !tm.Stop() {
+		t.Fatal("Stop on a pending timer returned false")
+	}
+	if tm.Stop() {
+		t.Fatal("second Stop returned true")
+	}
+	fc.Advance(2 * time.Second)
+	if fired(tm.C()) {
+		t.Fatal("stopped timer fired")
+	}
+
+	// Reset counts from now, not from when the timer was made
+	if tm.Reset(time.Second) {
+		t.Fatal("Reset of a stopped timer reported it active")
+	}
+	fc.Advance(900 * time.Millisecond)
+	if !tm.Reset(time.Second) {
+		t.Fatal("Reset of a pending timer reported it inactive")
+	}
+	fc.Advance(900 * time.Millisecond)
+	if fired(tm.C()) {
+		t.Fatal("timer fired on its old deadline")
+	}
+	fc.Advance(100 * time.Millisecond)
+	if !fired(tm.C()) {
+		t.Fatal("timer didn't fire on its reset deadline")
+	}
+
+	if !fired(fc.After(0)) {
+		t.Fatal("After(0) didn't fire straight away")
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	tk := fc.NewTicker(time.Second)
+	defer tk.Stop()
+
+	for i := 1; i <= 3; i++ {
+		fc.Advance(time.Second)
+		select {
+		case at := <-tk.C():
+			if want := epoch.Add(time.Duration(i) * time.Second); !at.Equal(want) {
+				t.Fatalf("tick %d at %v, want %v", i, at, want)
+			}
+		default:
+			t.Fatalf("no tick %d", i)
+		}
+	}
+
+	// like a real ticker, ticks nobody reads are dropped, not queued
+	fc.Advance(5 * time.Second)
+	if !fired(tk.C()) || fired(tk.C()) {
+		t.Fatal("want exactly one buffered tick after a long advance")
+	}
+
+	tk.Reset(3 * time.Second)
+	fc.Advance(2 * time.Second)
+	if fired(tk.C()) {
+		t.Fatal("ticked on the old period after Reset")
+	}
+	fc.Advance(time.Second)
+	if !fired(tk.C()) {
+		t.Fatal("no tick on the new period")
+	}
+
+	tk.Stop()
+	fc.Advance(time.Hour)
+	if fired(tk.C()) {
+		t.Fatal("stopped ticker ticked")
+	}
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	done := make(chan struct{})
+	go func() {
+		fc.Sleep(time.Minute)
+		close(done)
+	}()
+
+	// without BlockUntil the Advance could land before the Sleep starts
+	fc.BlockUntil(1)
+	fc.Advance(time.Minute)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sleeper didn't wake")
+	}
+}
+
+func TestWithTimeoutOnFakeClock(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	ctx, cancel := withTimeout(context.Background(), fc, time.Second)
+	defer cancel()
+	child, cancelChild := context.WithCancel(ctx)
+	defer cancelChild()
+
+	if d, ok := ctx.Deadline(); !ok || !d.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("Deadline() = %v, %v", d, ok)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("done before the timeout")
+	}
+
+	fc.Advance(time.Second)
+	<-ctx.Done()
+	<-child.Done()
+
+	for name, c := range map[string]context.Context{"ctx": ctx, "child": child} {
+		if err := c.Err(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s.Err() = %v, want DeadlineExceeded", name, err)
+		}
+		if err := context.Cause(c); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Cause(%s) = %v, want DeadlineExceeded", name, err)
+		}
+	}
+}
+
+func TestWithTimeoutCancel(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := withTimeout(parent, fc, time.Second)
+	defer cancel()
+
+	cancelParent()
+	<-ctx.Done()
+	if err := ctx.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("after parent cancel: Err() = %v, want Canceled", err)
+	}
+
+	ctx, cancel = withTimeout(context.Background(), fc, time.Second)
+	cancel()
+	// like the stdlib, done by the time cancel returns
+	if err := ctx.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("after cancel: Err() = %v, want Canceled", err)
+	}
+	if fc.Pending() != 0 {
+		t.Fatalf("cancel left %d timers behind", fc.Pending())
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	fc := NewFakeClock(epoch)
+
+	// a demo still reading the clock while it's swapped back mustn't race
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				demoClock().Now()
+			}
+		}
+	}()
+
+	restore := SetClock(fc)
+	if demoClock() != Clock(fc) {
+		t.Fatal("SetClock didn't swap the demo clock")
+	}
+	restore()
+	if _, ok := demoClock().(realClock); !ok {
+		t.Fatalf("restore left %T in place", demoClock())
+	}
+
+	close(stop)
+	<-done
+}
@@ -0,0 +1,62 @@
+package concpatterns
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync/atomic"
+)
+
+// cacheLine keeps each cell on its own line so shards don't false share.
+const cacheLine = 64
+
+type counterCell struct {
+	n atomic.Int64
+	_ [cacheLine - 8]byte
+}
+
+// Counter is a sharded atomic counter for hot paths. Add touches a single
+// randomly picked cell so concurrent writers rarely contend on the same cache
+// line; Load pays for that by summing all of them.
+type Counter struct {
+	cells []counterCell
+	mask  uint32
+}
+
+// NewCounter creates a counter with a cell per P, rounded up to a power of two.
+func NewCounter() *Counter {
+	n := 1
+	for n < runtime.GOMAXPROCS(0) {
+		n <<= 1
+	}
+	return &Counter{
+		cells: make([]counterCell, n),
+		mask:  uint32(n - 1),
+	}
+}
+
+// Add adds delta to the counter.
+func (c *Counter) Add(delta int64) {
+	// the top level math/rand/v2 source is per-P under the hood, so this is
+	// cheap and lands different Ps on different cells most of the time
+	c.cells[rand.Uint32()&c.mask].n.Add(delta)
+}
+
+// Load returns the current total. Adds racing with it may or may not be
+// included.
+func (c *Counter) Load() int64 {
+	var sum int64
+	for i := range c.cells {
+		sum += c.cells[i].n.Load()
+	}
+	return sum
+}
+
+// Reset zeroes the counter and returns the total it held. Adds racing with it
+// land either before or after the reset, never get lost.
+func (c *Counter) Reset() int64 {
+	var sum int64
+	for i := range c.cells {
+		sum += c.cells[i].n.Swap(0)
+	}
+	return sum
+}
@@ -0,0 +1,89 @@
+package concpatterns
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCounterConcurrent(t *testing.T) {
+	const (
+		grs = 8
+		n   = 10000
+	)
+
+	c := NewCounter()
+	var reset atomic.Int64
+	var wg sync.WaitGroup
+
+	wg.Add(grs)
+	for range grs {
+		go func() {
+			defer wg.Done()
+			for range n {
+				c.Add(1)
+			}
+		}()
+	}
+
+	// resets racing with the adds mustn't lose any of them
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			reset.Add(c.Reset())
+		}
+	}()
+
+	wg.Wait()
+	<-done
+
+	if got := reset.Load() + c.Load(); got != grs*n {
+		t.Fatalf("reset + load = %d, want %d", got, grs*n)
+	}
+	c.Reset()
+	if got := c.Load(); got != 0 {
+		t.Fatalf("Load after Reset = %d, want 0", got)
+	}
+}
+
+func TestCounterAddNegative(t *testing.T) {
+	c := NewCounter()
+	c.Add(5)
+	c.Add(-7)
+	if got := c.Load(); got != -2 {
+		t.Fatalf("Load() = %d, want -2", got)
+	}
+}
+
+// the contended trio: Counter vs the mutex from SyncWithMutex vs one atomic
+
+func BenchmarkCounter(b *testing.B) {
+	c := NewCounter()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add(1)
+		}
+	})
+}
+
+func BenchmarkRWMutexCounter(b *testing.B) {
+	var mu sync.RWMutex
+	var n int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			n++
+			mu.Unlock()
+		}
+	})
+}
+
+func BenchmarkAtomicCounter(b *testing.B) {
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n.Add(1)
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package concpatterns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueClosed is returned by Pop once the queue is closed and drained.
+var ErrQueueClosed = errors.New("queue closed")
+
+// DropPolicy decides what happens to a push when the queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the incoming item, same as Drop().
+	DropNewest DropPolicy = iota
+	// DropOldest evicts the oldest buffered item to make room.
+	DropOldest
+	// BlockWithTimeout waits up to Timeout for room, then drops the incoming item.
+	BlockWithTimeout
+	// SampleOneInN lets every SampleN-th overflowing item in by evicting the
+	// oldest; the others are dropped.
+	SampleOneInN
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case BlockWithTimeout:
+		return "block-with-timeout"
+	case SampleOneInN:
+		return "sample-one-in-n"
+	default:
+		return fmt.Sprintf("DropPolicy(%d)", int(p))
+	}
+}
+
+// DropQueueConfig configures a DropQueue.
+type DropQueueConfig[T any] struct {
+	Capacity int
+	Policy   DropPolicy
+	Timeout  time.Duration // BlockWithTimeout only
+	SampleN  int           // SampleOneInN only
+	OnDrop   func(T)       // called for every dropped or evicted item, may call Close
+	Clock    Clock         // times BlockWithTimeout, nil means the real clock
+}
+
+// DropStats counts what a DropQueue has done so far.
+type DropStats struct {
+	Accepted  uint64 `json:"accepted"`
+	Dropped   uint64 `json:"dropped"`
+	Delivered uint64 `json:"delivered"`
+	Len       int    `json:"len"`
+}
+
+// DropQueue is the Drop pattern with a choice of what gets shed under load
+// and counters to show how much of it actually happens.
+type DropQueue[T any] struct {
+	cfg DropQueueConfig[T]
+	ch  chan T
+
+	// pushers hold mu for reading so Close can't close ch under them
+	mu     sync.RWMutex
+	closed bool
+
+	// closing is closed as Close starts, to get pushes blocked waiting for
+	// room to let go of mu
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	evictMu  sync.Mutex
+	overflow atomic.Uint64
+
+	accepted  atomic.Uint64
+	dropped   atomic.Uint64
+	delivered atomic.Uint64
+}
+
+// NewDropQueue creates a queue buffering up to cfg.Capacity items. With no
+// capacity there's nothing to evict, so DropOldest and SampleOneInN fall back
+// to DropNewest.
+func NewDropQueue[T any](cfg DropQueueConfig[T]) *DropQueue[T] {
+	if cfg.SampleN <= 0 {
+		cfg.SampleN = 1
+	}
+	cfg.Clock = orReal(cfg.Clock)
+	if cfg.Capacity <= 0 && (cfg.Policy == DropOldest || cfg.Policy == SampleOneInN) {
+		cfg.Policy = DropNewest
+	}
+	return &DropQueue[T]{
+		cfg:     cfg,
+		ch:      make(chan T, cfg.Capacity),
+		closing: make(chan struct{}),
+	}
+}
+
+// Push offers v to the queue and reports whether it was accepted. An accepted
+// item may still be evicted later under DropOldest or SampleOneInN. A push
+// waiting for room under BlockWithTimeout gives up as soon as Close is called,
+// and is turned away as if it came after.
+func (q *DropQueue[T]) Push(v T) bool {
+	ok, dropped := q.push(v)
+
+	// OnDrop runs once the locks are let go, so it's free to call Close
+	q.dropped.Add(uint64(len(dropped)))
+	if q.cfg.OnDrop != nil {
+		for _, d := range dropped {
+			q.cfg.OnDrop(d)
+		}
+	}
+	return ok
+}
+
+// push does the work of Push, returning what it dropped on the way.
+func (q *DropQueue[T]) push(v T) (bool, []T) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return false, nil
+	}
+
+	select {
+	case q.ch <- v:
+		q.accepted.Add(1)
+		return true, nil
+	default:
+	}
+
+	switch q.cfg.Policy {
+	case DropOldest:
+		return true, q.evictAndPush(v)
+
+	case BlockWithTimeout:
+		t := q.cfg.Clock.NewTimer(q.cfg.Timeout)
+		defer t.Stop()
+		select {
+		case q.ch <- v:
+			q.accepted.Add(1)
+			return true, nil
+		case <-t.C():
+		case <-q.closing:
+			return false, nil
+		}
+
+	case SampleOneInN:
+		if q.overflow.Add(1)%uint64(q.cfg.SampleN) == 0 {
+			return true, q.evictAndPush(v)
+		}
+	}
+
+	return false, []T{v}
+}
+
+// Pop blocks for the next item. Once the queue is closed, buffered items are
+// still handed out before ErrQueueClosed is returned.
+func (q *DropQueue[T]) Pop(ctx context.Context) (T, error) {
+	select {
+	case v, ok := <-q.ch:
+		if !ok {
+			var zero T
+			return zero, ErrQueueClosed
+		}
+		q.delivered.Add(1)
+		return v, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Close stops accepting pushes. Safe to call more than once.
+func (q *DropQueue[T]) Close() {
+	q.closeOnce.Do(func() { close(q.closing) })
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (q *DropQueue[T]) Stats() DropStats {
+	return DropStats{
+		Accepted:  q.accepted.Load(),
+		Dropped:   q.dropped.Load(),
+		Delivered: q.delivered.Load(),
+		Len:       len(q.ch),
+	}
+}
+
+// String renders Stats as JSON, which makes the queue an expvar.Var that can
+// be published and scraped as is.
+func (q *DropQueue[T]) String() string {
+	b, _ := json.Marshal(q.Stats())
+	return string(b)
+}
+
+// evictAndPush makes room for v by dropping the oldest items, and returns
+// them. Must be called with mu held for reading, on a queue with capacity.
+func (q *DropQueue[T]) evictAndPush(v T) []T {
+	q.evictMu.Lock()
+	defer q.evictMu.Unlock()
+
+	var evicted []T
+	for {
+		select {
+		case q.ch <- v:
+			q.accepted.Add(1)
+			return evicted
+		default:
+		}
+
+		// a consumer may beat us to it, in which case there's room next round
+		select {
+		case old := <-q.ch:
+			evicted = append(evicted, old)
+		default:
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package concpatterns
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDropQueuePolicies(t *testing.T) {
+	tests := []struct {
+		policy  DropPolicy
+		sampleN int
+		pushed  []int
+		want    []int
+		dropped []int
+	}{
+		{DropNewest, 0, []int{1, 2, 3, 4}, []int{1, 2}, []int{3, 4}},
+		{DropOldest, 0, []int{1, 2, 3, 4}, []int{3, 4}, []int{1, 2}},
+		{BlockWithTimeout, 0, []int{1, 2, 3}, []int{1, 2}, []int{3}},
+		// every 2nd overflowing item gets in at the oldest's expense
+		{SampleOneInN, 2, []int{1, 2, 3, 4, 5, 6}, []int{4, 6}, []int{3, 1, 5, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			var dropped []int
+			q := NewDropQueue(DropQueueConfig[int]{
+				Capacity: 2,
+				Policy:   tt.policy,
+				Timeout:  time.Millisecond,
+				SampleN:  tt.sampleN,
+				OnDrop:   func(v int) { dropped = append(dropped, v) },
+			})
+			for _, v := range tt.pushed {
+				q.Push(v)
+			}
+			q.Close()
+
+			var got []int
+			for {
+				v, err := q.Pop(context.Background())
+				if err != nil {
+					break
+				}
+				got = append(got, v)
+			}
+
+			if !slices.Equal(got, tt.want) || !slices.Equal(dropped, tt.dropped) {
+				t.Fatalf("got %v dropped %v, want %v dropped %v", got, dropped, tt.want, tt.dropped)
+			}
+			if st := q.Stats(); st.Dropped != uint64(len(tt.dropped)) {
+				t.Fatalf("stats dropped = %d, want %d", st.Dropped, len(tt.dropped))
+			}
+		})
+	}
+}
+
+func TestDropQueueZeroCapacity(t *testing.T) {
+	for _, p := range []DropPolicy{DropOldest, SampleOneInN} {
+		q := NewDropQueue(DropQueueConfig[int]{Policy: p, SampleN: 1})
+
+		done := make(chan bool)
+		go func() { done <- q.Push(1) }()
+
+		select {
+		case ok := <-done:
+			if ok {
+				t.Errorf("%v: Push with no capacity or consumer was accepted", p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: Push spun with no capacity", p)
+		}
+	}
+}
+
+func TestDropQueueOnDropCanClose(t *testing.T) {
+	var q *DropQueue[int]
+	q = NewDropQueue(DropQueueConfig[int]{
+		Capacity: 1,
+		Policy:   DropOldest,
+		OnDrop:   func(int) { q.Close() },
+	})
+
+	done := make(chan struct{})
+	go func() {
+		q.Push(1)
+		q.Push(2) // evicts 1, and OnDrop closes
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close from OnDrop deadlocked")
+	}
+	if q.Push(3) {
+		t.Fatal("Push accepted after Close")
+	}
+}
+
+func TestDropQueueCloseWakesBlockedPush(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	q := NewDropQueue(DropQueueConfig[int]{
+		Capacity: 1,
+		Policy:   BlockWithTimeout,
+		Timeout:  time.Hour,
+		Clock:    fc,
+	})
+	q.Push(1)
+
+	pushed := make(chan bool)
+	go func() { pushed <- q.Push(2) }()
+	fc.BlockUntil(1)
+
+	closed := make(chan struct{})
+	go func() {
+		q.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited on the blocked push")
+	}
+	if <-pushed {
+		t.Fatal("push blocked across Close was accepted")
+	}
+	if st := q.Stats(); st.Accepted != 1 || st.Dropped != 0 {
+		t.Fatalf("stats = %+v, want 1 accepted and nothing dropped", st)
+	}
+}
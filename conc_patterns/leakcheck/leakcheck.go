@@ -0,0 +1,183 @@
+// Package leakcheck finds goroutines a test started but didn't wait for.
+//
+// The typical use is deferring the check at the top of a test:
+//
+//	defer leakcheck.Check(t)()
+package leakcheck
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TB is the part of testing.TB the checks need.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// Goroutine is one goroutine out of a runtime.Stack dump.
+type Goroutine struct {
+	ID    string
+	State string
+	Stack string // the full trace, header included
+}
+
+// Snapshot is the set of goroutines running at some point.
+type Snapshot map[string]Goroutine
+
+// ignored are frames of goroutines the runtime and the testing package start
+// on their own, which would otherwise be reported as leaks.
+var ignored = []string{
+	"testing.tRunner(",
+	"testing.(*T).Run(",
+	"testing.(*T).Parallel(",
+	"testing.(*M).startAlarm",
+	"testing.runFuzzing(",
+	"testing.RunTests(",
+	"runtime.goexit0(",
+	"runtime.ensureSigM(",
+	"os/signal.signal_recv(",
+	"os/signal.loop(",
+	"runtime/trace.Start",
+	"runtime.ReadTrace(",
+	"runtime.gc",
+	"runtime.bgsweep(",
+	"runtime.bgscavenge(",
+	"runtime.forcegchelper(",
+	"runtime.runfinq(",
+}
+
+// Take snapshots the goroutines running now.
+func Take() Snapshot {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	snap := make(Snapshot)
+	for _, block := range bytes.Split(buf, []byte("\n\n")) {
+		if g, ok := parse(string(block)); ok {
+			snap[g.ID] = g
+		}
+	}
+	return snap
+}
+
+// parse reads one block of a runtime.Stack dump, which starts with a header
+// like "goroutine 12 [chan receive]:".
+func parse(block string) (Goroutine, bool) {
+	header, _, _ := strings.Cut(block, "\n")
+	rest, ok := strings.CutPrefix(header, "goroutine ")
+	if !ok {
+		return Goroutine{}, false
+	}
+
+	id, state, ok := strings.Cut(rest, " ")
+	if !ok {
+		return Goroutine{}, false
+	}
+	state = strings.TrimSuffix(strings.TrimPrefix(state, "["), "]:")
+
+	return Goroutine{ID: id, State: state, Stack: block}, true
+}
+
+// Option tweaks how Check and Find look for leaks.
+type Option func(*config)
+
+type config struct {
+	timeout time.Duration
+	ignore  []string
+}
+
+// Timeout sets how long goroutines get to exit before they count as leaked.
+// Defaults to a second: most leftovers are just a little slow to return.
+func Timeout(d time.Duration) Option {
+	return func(c *config) { c.timeout = d }
+}
+
+// Ignore skips goroutines with a frame containing any of fns, e.g.
+// "mypkg.(*Server).serve(".
+func Ignore(fns ...string) Option {
+	return func(c *config) { c.ignore = append(c.ignore, fns...) }
+}
+
+// Find returns the goroutines running now that weren't in before, waiting for
+// up to the timeout for them to go away first.
+func Find(before Snapshot, opts ...Option) []Goroutine {
+	cfg := config{timeout: time.Second, ignore: ignored}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	deadline := time.Now().Add(cfg.timeout)
+	backoff := time.Millisecond
+	for {
+		leaked := diff(before, Take(), cfg.ignore)
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return leaked
+		}
+
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 100*time.Millisecond)
+	}
+}
+
+func diff(before, after Snapshot, ignore []string) []Goroutine {
+	self := currentID()
+
+	var leaked []Goroutine
+	for id, g := range after {
+		if _, ok := before[id]; ok || id == self {
+			continue
+		}
+		if slices.ContainsFunc(ignore, func(fn string) bool {
+			return strings.Contains(g.Stack, fn)
+		}) {
+			continue
+		}
+		leaked = append(leaked, g)
+	}
+
+	slices.SortFunc(leaked, func(a, b Goroutine) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return leaked
+}
+
+func currentID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	g, _ := parse(string(buf))
+	return g.ID
+}
+
+// Check snapshots the running goroutines and returns a func that fails t with
+// the stack of every goroutine started since that's still running.
+func Check(t TB, opts ...Option) func() {
+	before := Take()
+
+	return func() {
+		t.Helper()
+
+		leaked := Find(before, opts...)
+		if len(leaked) == 0 {
+			return
+		}
+
+		var sb strings.Builder
+		for _, g := range leaked {
+			fmt.Fprintf(&sb, "\n%s\n", g.Stack)
+		}
+		t.Errorf("leakcheck: %d goroutine(s) leaked:%s", len(leaked), sb.String())
+	}
+}
@@ -0,0 +1,75 @@
+package leakcheck
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder is a TB that keeps the failures instead of failing the test.
+type recorder struct {
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func leakyWorker(block chan struct{}) { <-block }
+
+func TestCheckFindsLeak(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	var r recorder
+	check := Check(&r, Timeout(50*time.Millisecond))
+	go leakyWorker(block)
+	check()
+
+	if len(r.errs) != 1 {
+		t.Fatalf("got %d failures, want 1", len(r.errs))
+	}
+	if !strings.Contains(r.errs[0], "1 goroutine(s) leaked") || !strings.Contains(r.errs[0], "leakyWorker") {
+		t.Fatalf("failure doesn't name the leaked goroutine:\n%s", r.errs[0])
+	}
+}
+
+func TestCheckWaitsForSlowExit(t *testing.T) {
+	var r recorder
+	check := Check(&r)
+	go time.Sleep(20 * time.Millisecond)
+	check()
+
+	if len(r.errs) != 0 {
+		t.Fatalf("goroutine that exits within the timeout reported:\n%s", r.errs[0])
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	var r recorder
+	check := Check(&r, Timeout(50*time.Millisecond), Ignore("leakcheck.leakyWorker("))
+	go leakyWorker(block)
+	check()
+
+	if len(r.errs) != 0 {
+		t.Fatalf("ignored goroutine reported:\n%s", r.errs[0])
+	}
+}
+
+func TestFindIgnoresGoroutinesFromBefore(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	go leakyWorker(block)
+	time.Sleep(10 * time.Millisecond)
+
+	if leaked := Find(Take(), Timeout(10*time.Millisecond)); len(leaked) != 0 {
+		t.Fatalf("Find reported %d goroutines that were already running", len(leaked))
+	}
+}
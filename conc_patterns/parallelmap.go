@@ -0,0 +1,110 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrorMode picks how ParallelMapMode deals with failing items.
+type ErrorMode int
+
+const (
+	// StopOnFirstError cancels the remaining work on the first failure.
+	StopOnFirstError ErrorMode = iota
+	// CollectAllErrors runs every item and joins all the failures.
+	CollectAllErrors
+)
+
+// ItemError ties an error to the index of the item that caused it.
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// ParallelMap is FanOut that keeps track of where each result came from: the
+// output is in input order, at most workers items run at once and the first
+// error cancels the rest.
+func ParallelMap[T, R any](ctx context.Context, items []T, workers int, fn func(context.Context, T) (R, error)) ([]R, error) {
+	return ParallelMapMode(ctx, items, workers, StopOnFirstError, fn)
+}
+
+// ParallelMapMode is ParallelMap with a choice of ErrorMode. Under
+// CollectAllErrors the results are returned alongside the joined errors, with
+// zero values where items failed.
+func ParallelMapMode[T, R any](ctx context.Context, items []T, workers int, mode ErrorMode, fn func(context.Context, T) (R, error)) ([]R, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+
+	var (
+		next     atomic.Int64
+		firstErr error
+		once     sync.Once
+		wg       sync.WaitGroup
+	)
+
+	// only as many goroutines as workers, pulling the next index as they go,
+	// instead of one per item up front
+	grs := min(max(workers, 1), len(items))
+	wg.Add(grs)
+	for range grs {
+		go func() {
+			defer wg.Done()
+			for {
+				if ctx.Err() != nil {
+					return
+				}
+				i := int(next.Add(1) - 1)
+				if i >= len(items) {
+					return
+				}
+
+				r, err := fn(ctx, items[i])
+				if err != nil {
+					errs[i] = &ItemError{Index: i, Err: err}
+					if mode == StopOnFirstError {
+						once.Do(func() {
+							firstErr = errs[i]
+							cancel()
+						})
+						return
+					}
+					continue
+				}
+				results[i] = r
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the caller's ctx going away leaves items that never ran
+	var ctxErr error
+	if int(next.Load()) < len(items) {
+		ctxErr = ctx.Err()
+	}
+
+	if mode == StopOnFirstError {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		if ctxErr != nil {
+			return nil, ctxErr
+		}
+		return results, nil
+	}
+
+	return results, errors.Join(append(errs, ctxErr)...)
+}
@@ -0,0 +1,138 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+func TestParallelMapOrderAndLimit(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	const workers = 3
+	var running, peak atomic.Int32
+	items := make([]int, 50)
+	for i := range items {
+		items[i] = i
+	}
+
+	got, err := ParallelMap(context.Background(), items, workers, func(_ context.Context, n int) (int, error) {
+		r := running.Add(1)
+		defer running.Add(-1)
+		for {
+			if old := peak.Load(); r <= old || peak.CompareAndSwap(old, r) {
+				break
+			}
+		}
+		// later items finish first, so arrival order is scrambled
+		time.Sleep(time.Duration(50-n) * 10 * time.Microsecond)
+		return n * 2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, r := range got {
+		if r != i*2 {
+			t.Fatalf("result %d = %d, want %d", i, r, i*2)
+		}
+	}
+	if p := peak.Load(); p > workers {
+		t.Fatalf("%d calls at once, want at most %d", p, workers)
+	}
+}
+
+func TestParallelMapStopOnFirstError(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	errBad := errors.New("bad item")
+	var calls atomic.Int32
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+
+	got, err := ParallelMap(context.Background(), items, 2, func(ctx context.Context, n int) (int, error) {
+		calls.Add(1)
+		if n == 5 {
+			return 0, errBad
+		}
+		if n > 5 {
+			// whatever's still running once 5 fails is cancelled
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return n, nil
+	})
+
+	var ie *ItemError
+	if !errors.As(err, &ie) || ie.Index != 5 || !errors.Is(err, errBad) {
+		t.Fatalf("err = %v, want item 5's error", err)
+	}
+	if got != nil {
+		t.Fatalf("results = %v, want nil", got)
+	}
+	if n := calls.Load(); n >= int32(len(items)) {
+		t.Fatalf("all %d items ran after the first error", n)
+	}
+}
+
+func TestParallelMapCollectAllErrors(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	errOdd := errors.New("odd")
+	items := []int{0, 1, 2, 3, 4, 5}
+
+	got, err := ParallelMapMode(context.Background(), items, 2, CollectAllErrors, func(_ context.Context, n int) (string, error) {
+		if n%2 == 1 {
+			return "failed", errOdd
+		}
+		return "ok", nil
+	})
+
+	if want := []string{"ok", "", "ok", "", "ok", ""}; !slices.Equal(got, want) {
+		t.Fatalf("results = %q, want %q", got, want)
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("err = %v, want the joined item errors", err)
+	}
+	var indexes []int
+	for _, e := range joined.Unwrap() {
+		var ie *ItemError
+		if !errors.As(e, &ie) || !errors.Is(e, errOdd) {
+			t.Fatalf("joined error %v isn't an item error", e)
+		}
+		indexes = append(indexes, ie.Index)
+	}
+	if !slices.Equal(indexes, []int{1, 3, 5}) {
+		t.Fatalf("failed items %v, want [1 3 5]", indexes)
+	}
+}
+
+func TestParallelMapCancelled(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, mode := range []ErrorMode{StopOnFirstError, CollectAllErrors} {
+		var calls atomic.Int32
+		_, err := ParallelMapMode(ctx, []int{1, 2, 3}, 2, mode, func(context.Context, int) (int, error) {
+			calls.Add(1)
+			return 0, nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("mode %d: err = %v, want context.Canceled", mode, err)
+		}
+		if n := calls.Load(); n != 0 {
+			t.Fatalf("mode %d: %d items ran under a cancelled ctx", mode, n)
+		}
+	}
+}
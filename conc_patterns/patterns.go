@@ -9,11 +9,10 @@ import (
 	"time"
 )
 
-var counter int32
-
 func SyncWithMutex() {
 	const grs = 2
 
+	var counter int32
 	var wg sync.WaitGroup
 	var mu sync.RWMutex
 	wg.Add(grs)
@@ -46,12 +45,40 @@ func SyncWithMutex() {
 	fmt.Printf("counter: %v\n", counter)
 }
 
+// SyncWithCounter is SyncWithMutex with a Counter in place of the mutex
+// guarded int: each Add is atomic, so there's no lock to hold across the
+// read-modify-write.
+func SyncWithCounter() {
+	const grs = 2
+
+	counter := NewCounter()
+	var wg sync.WaitGroup
+	wg.Add(grs)
+
+	for i := 0; i < grs; i++ {
+		go func() {
+			defer wg.Done()
+
+			for count := 0; count < 2; count++ {
+				counter.Add(1)
+				fmt.Println("logging")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// NOTE: 4 again, without any locking
+	fmt.Printf("counter: %v\n", counter.Load())
+}
+
 // NOTE: channel patterns
 func WaitForResult() {
+	clock := demoClock()
 	ch := make(chan string)
 
 	go func() {
-		time.Sleep(time.Duration(rand.Intn(500) * int(time.Millisecond)))
+		clock.Sleep(time.Duration(rand.Intn(500) * int(time.Millisecond)))
 		ch <- "paper"
 		println("signal sent")
 	}()
@@ -59,16 +86,17 @@ func WaitForResult() {
 	p := <-ch
 	fmt.Printf("signal received: %v\n", p)
 
-	time.Sleep(time.Second)
+	clock.Sleep(time.Second)
 }
 
 func FanOut() {
+	clock := demoClock()
 	workers := 2000
 	ch := make(chan string, workers)
 
 	for e := range workers {
 		go func(emp int) {
-			time.Sleep(time.Duration(rand.Intn(200) * int(time.Millisecond)))
+			clock.Sleep(time.Duration(rand.Intn(200) * int(time.Millisecond)))
 			ch <- "paper"
 			fmt.Printf("sent signal: emp %d\n", emp)
 		}(e)
@@ -81,27 +109,68 @@ func FanOut() {
 		fmt.Printf("received signal: %v\n", workers)
 	}
 
-	time.Sleep(time.Second)
+	clock.Sleep(time.Second)
 	fmt.Println("---------------------------------------------------")
 }
 
+// FanOutCtx is FanOut that gives up once ctx is done. It returns how many
+// signals were received, plus ctx.Err() if it was cancelled before all came in.
+func FanOutCtx(ctx context.Context) (int, error) {
+	clock := demoClock()
+	workers := 2000
+	// buffered so the senders never block, even with nobody left receiving
+	ch := make(chan string, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	// whichever way we return, no sender outlives the call
+	defer wg.Wait()
+
+	for e := range workers {
+		go func(emp int) {
+			defer wg.Done()
+			if !sleepCtx(ctx, clock, time.Duration(rand.Intn(200))*time.Millisecond) {
+				return
+			}
+			ch <- "paper"
+			fmt.Printf("sent signal: emp %d\n", emp)
+		}(e)
+	}
+
+	received := 0
+	for received < workers {
+		select {
+		case p := <-ch:
+			received++
+			fmt.Printf("p: %v\n", p)
+			fmt.Printf("received signal: %v\n", workers-received)
+		case <-ctx.Done():
+			return received, ctx.Err()
+		}
+	}
+
+	return received, nil
+}
+
 func FanOutSemaphore() {
+	clock := demoClock()
 	workers := 2000
 	ch := make(chan string, workers)
 
 	grs := runtime.NumCPU()
-	sem := make(chan bool, grs)
+	sem := NewSemaphore(int64(grs))
 
 	for w := range workers {
 		go func() {
-			sem <- true
+			// can't fail: Background is never cancelled and 1 <= grs
+			_ = sem.Acquire(context.Background(), 1)
 
-			time.Sleep(time.Duration(rand.Intn(200) * int(time.Millisecond)))
+			clock.Sleep(time.Duration(rand.Intn(200) * int(time.Millisecond)))
 			ch <- "paper"
 			// capture that loop variable
 			fmt.Println("worker : signal sent: ", w)
 
-			<-sem
+			sem.Release(1)
 		}()
 	}
 
@@ -113,6 +182,52 @@ func FanOutSemaphore() {
 	}
 }
 
+// FanOutSemaphoreCtx is FanOutSemaphore that gives up once ctx is done. Workers
+// still waiting on the semaphore bail out instead of running.
+func FanOutSemaphoreCtx(ctx context.Context) (int, error) {
+	clock := demoClock()
+	workers := 2000
+	ch := make(chan string, workers)
+
+	grs := runtime.NumCPU()
+	sem := NewSemaphore(int64(grs))
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	defer wg.Wait()
+
+	for w := range workers {
+		go func() {
+			defer wg.Done()
+
+			if err := sem.Acquire(ctx, 1); err != nil {
+				return
+			}
+			defer sem.Release(1)
+
+			if !sleepCtx(ctx, clock, time.Duration(rand.Intn(200))*time.Millisecond) {
+				return
+			}
+			ch <- "paper"
+			fmt.Println("worker : signal sent: ", w)
+		}()
+	}
+
+	received := 0
+	for received < workers {
+		select {
+		case p := <-ch:
+			received++
+			fmt.Printf("%s\n", p)
+			fmt.Printf("signal received: %d\n", workers-received)
+		case <-ctx.Done():
+			return received, ctx.Err()
+		}
+	}
+
+	return received, nil
+}
+
 func Pooling() {
 	ch := make(chan string)
 	grs := runtime.NumCPU()
@@ -138,43 +253,128 @@ func Pooling() {
 	fmt.Println("---------------------------------------------------")
 }
 
-func FanOutBounded() {
-	work := []string{"paper", "paper", "paper", 2000: "paper"}
-	fmt.Printf("work: %v\n", work)
-
+// PoolingCtx is Pooling that stops handing out work once ctx is done. The
+// shutdown signal is still sent and every employee has exited on return. It
+// returns how much work was handed out.
+func PoolingCtx(ctx context.Context) (int, error) {
+	ch := make(chan string)
 	grs := runtime.NumCPU()
+
 	var wg sync.WaitGroup
 	wg.Add(grs)
 
-	ch := make(chan string, grs) // buf chan for workers
-
-	for worker := range grs {
+	for e := range grs {
 		go func() {
 			defer wg.Done()
 			for p := range ch {
-				fmt.Printf("%d : received signal : %s\n", worker, p)
+				fmt.Printf("emp %d received signal:) %v\n", e, p)
 			}
-			fmt.Printf("%d : received shutdown signal\n", worker)
+			fmt.Printf("emp %d received shutdown signal\n", e)
 		}()
 	}
 
-	for _, w := range work {
-		ch <- w
+	sent := 0
+	var err error
+work:
+	for sent < 100 {
+		select {
+		case ch <- "paper":
+			fmt.Printf("signal sent: %v\n", sent)
+			sent++
+		case <-ctx.Done():
+			err = ctx.Err()
+			break work
+		}
 	}
 
 	close(ch)
 	wg.Wait()
+	fmt.Printf("shutdown signal sent\n")
+	return sent, err
+}
+
+func FanOutBounded() {
+	work := []string{"paper", "paper", "paper", 2000: "paper"}
+	fmt.Printf("work: %v\n", work)
+
+	pool := NewPool(runtime.NumCPU(), func(_ context.Context, p string) (string, error) {
+		return p, nil
+	})
+
+	go func() {
+		for _, w := range work {
+			if err := pool.Submit(context.Background(), w); err != nil {
+				fmt.Printf("submit failed: %v\n", err)
+			}
+		}
+		pool.Close()
+	}()
+
+	for r := range pool.Results() {
+		fmt.Printf("received signal : %s\n", r.Out)
+	}
+
+	pool.Wait()
+	fmt.Printf("received shutdown signal\n")
 	fmt.Println("---------------------------------------------------")
 }
 
+// FanOutBoundedCtx is FanOutBounded that stops submitting once ctx is done.
+// Work already buffered in the pool is abandoned rather than processed. It
+// returns how many items were processed.
+func FanOutBoundedCtx(ctx context.Context) (int, error) {
+	work := []string{"paper", "paper", "paper", 2000: "paper"}
+
+	pool := NewPool(runtime.NumCPU(), func(ctx context.Context, p string) (string, error) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return p, nil
+	})
+
+	go func() {
+		defer pool.Close()
+		for _, w := range work {
+			if err := pool.Submit(ctx, w); err != nil {
+				return
+			}
+		}
+	}()
+
+	done := 0
+	for r := range pool.Results() {
+		if r.Err != nil {
+			continue
+		}
+		done++
+		fmt.Printf("received signal : %s\n", r.Out)
+	}
+	pool.Wait()
+
+	if done < len(work) {
+		return done, ctx.Err()
+	}
+	return done, nil
+}
+
 func Drop() {
-	cap := 100
-	ch := make(chan string, cap) // blocks over 100
+	clock := demoClock()
+	q := NewDropQueue(DropQueueConfig[string]{
+		Capacity: 100, // drops over 100
+		Policy:   DropNewest,
+		OnDrop: func(string) {
+			fmt.Printf("data dropped\n")
+		},
+	})
 
 	// a single routine to keep the code simple
 	go func() {
 		// receiving
-		for p := range ch {
+		for {
+			p, err := q.Pop(context.Background())
+			if err != nil {
+				return
+			}
 			fmt.Println("signal received:", p)
 		}
 	}()
@@ -182,24 +382,65 @@ func Drop() {
 	const work = 2000
 	// sending
 	for w := range work {
-		select {
-		// if this blocks, go to default and drop
-		case ch <- "paper":
+		if q.Push("paper") {
 			fmt.Printf("signal sent: %d\n", w)
-		default:
-			fmt.Printf("data dropped\n")
 		}
 	}
-	close(ch)
+	q.Close()
 
-	time.Sleep(time.Second)
+	clock.Sleep(time.Second)
 	fmt.Printf("sending shutdown signal\n")
+	fmt.Printf("stats: %s\n", q)
 	fmt.Println("---------------------------------------------------")
 }
 
+// DropCtx is Drop that stops sending once ctx is done. The receiver drains
+// whatever is still buffered and has exited on return. It returns how many
+// signals were sent (not dropped).
+func DropCtx(ctx context.Context) (int, error) {
+	q := NewDropQueue(DropQueueConfig[string]{
+		Capacity: 100,
+		Policy:   DropNewest,
+		OnDrop: func(string) {
+			fmt.Printf("data dropped\n")
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			p, err := q.Pop(context.Background())
+			if err != nil {
+				return
+			}
+			fmt.Println("signal received:", p)
+		}
+	}()
+
+	const work = 2000
+	sent := 0
+	var err error
+	for w := range work {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if q.Push("paper") {
+			sent++
+			fmt.Printf("signal sent: %d\n", w)
+		}
+	}
+	q.Close()
+
+	<-done
+	fmt.Printf("sending shutdown signal\n")
+	return sent, err
+}
+
 func Cancellation() {
+	clock := demoClock()
 	duration := 150 * time.Millisecond
-	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	ctx, cancel := withTimeout(context.Background(), clock, duration)
 	defer cancel()
 
 	// on an unbuffered channel, a routine might leak when continues past the timeout
@@ -207,7 +448,7 @@ func Cancellation() {
 	ch := make(chan string, 1)
 
 	go func() {
-		time.Sleep(time.Duration(rand.Intn(300)) * time.Millisecond)
+		clock.Sleep(time.Duration(rand.Intn(300)) * time.Millisecond)
 		ch <- "paper"
 	}()
 
@@ -222,45 +463,28 @@ func Cancellation() {
 		fmt.Println("work cancelled")
 	}
 
-	time.Sleep(time.Second)
+	clock.Sleep(time.Second)
 	fmt.Printf("sending shutdown signal\n")
 	fmt.Println("---------------------------------------------------")
 }
 
-// RunWorkerWithStop with a stop func
-func RunWorkerWithStop(process func(int, time.Time) error) func() {
-	const (
-		estimatedCheckFreq time.Duration = time.Second * 5
-		checkSelectLimit   int           = 50
-		shutdownTimeout    time.Duration = time.Second * 15
-	)
+// sleepCtx sleeps for d on c, or less if ctx is done first. It reports
+// whether the full duration was slept.
+func sleepCtx(ctx context.Context, c Clock, d time.Duration) bool {
+	t := c.NewTimer(d)
+	defer t.Stop()
 
-	ticker := time.NewTicker(estimatedCheckFreq)
-	processStopCh := make(chan chan struct{}, 1)
-
-	go func() {
-		for {
-			select {
-			case now := <-ticker.C:
-				if err := process(checkSelectLimit, now); err != nil {
-					fmt.Printf("Failed to run check: %v", err)
-				}
-			case ret := <-processStopCh:
-				close(ret)
-				return
-			}
-		}
-	}()
-
-	return func() {
-		ticker.Stop()
-		ret := make(chan struct{})
-		processStopCh <- ret
-		select {
-		case <-time.After(shutdownTimeout):
-			fmt.Printf("Failed to gracefully stop check worker\n")
-		case <-ret:
-			fmt.Printf("Gracefully stopped check worker\n")
-		}
+	select {
+	case <-t.C():
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
+
+// RunWorkerWithStop with a stop func
+func RunWorkerWithStop(process func(context.Context, int, time.Time) error) StopFunc {
+	opts := DefaultWorkerOptions()
+	opts.Clock = demoClock()
+	return RunWorkerWithOptions(process, opts)
+}
@@ -0,0 +1,166 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+// onFakeClock runs fn with the package clock swapped for a fake one that's
+// kept moving in the background, so the demos' sleeps pass in no real time.
+func onFakeClock(t *testing.T, fn func(fc *FakeClock)) {
+	t.Helper()
+
+	fc := NewFakeClock(epoch)
+	defer SetClock(fc)()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				fc.Advance(10 * time.Millisecond)
+				runtime.Gosched()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	fn(fc)
+}
+
+var ctxPatterns = []struct {
+	name string
+	fn   func(context.Context) (int, error)
+	// how many a full run gets through; DropCtx drops some, so it's a bound
+	total  int
+	atMost bool
+	sleeps bool
+}{
+	{"FanOutCtx", FanOutCtx, 2000, false, true},
+	{"FanOutSemaphoreCtx", FanOutSemaphoreCtx, 2000, false, true},
+	{"PoolingCtx", PoolingCtx, 100, false, false},
+	{"FanOutBoundedCtx", FanOutBoundedCtx, 2001, false, false},
+	{"DropCtx", DropCtx, 2000, true, false},
+}
+
+func TestCtxPatternsComplete(t *testing.T) {
+	for _, p := range ctxPatterns {
+		t.Run(p.name, func(t *testing.T) {
+			defer leakcheck.Check(t)()
+
+			onFakeClock(t, func(*FakeClock) {
+				n, err := p.fn(context.Background())
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				if n > p.total || (!p.atMost && n != p.total) || n == 0 {
+					t.Fatalf("n = %d, want %d", n, p.total)
+				}
+			})
+		})
+	}
+}
+
+func TestCtxPatternsCancelled(t *testing.T) {
+	for _, p := range ctxPatterns {
+		t.Run(p.name, func(t *testing.T) {
+			defer leakcheck.Check(t)()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			onFakeClock(t, func(*FakeClock) {
+				n, err := p.fn(ctx)
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("err = %v, want context.Canceled", err)
+				}
+				if n >= p.total {
+					t.Fatalf("n = %d, want fewer than %d from a cancelled run", n, p.total)
+				}
+			})
+		})
+	}
+}
+
+func TestCtxPatternsDeadline(t *testing.T) {
+	for _, p := range ctxPatterns {
+		if !p.sleeps {
+			continue
+		}
+		t.Run(p.name, func(t *testing.T) {
+			defer leakcheck.Check(t)()
+
+			onFakeClock(t, func(fc *FakeClock) {
+				// the workers sleep up to 200ms, so some can't make it in 100
+				ctx, cancel := withTimeout(context.Background(), fc, 100*time.Millisecond)
+				defer cancel()
+
+				n, err := p.fn(ctx)
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+				}
+				if n >= p.total {
+					t.Fatalf("n = %d, want fewer than %d past the deadline", n, p.total)
+				}
+			})
+		})
+	}
+}
+
+func TestPatternsDontLeak(t *testing.T) {
+	patterns := []struct {
+		name string
+		fn   func()
+	}{
+		{"WaitForResult", WaitForResult},
+		{"FanOut", FanOut},
+		{"FanOutSemaphore", FanOutSemaphore},
+		{"Pooling", Pooling},
+		{"FanOutBounded", FanOutBounded},
+		{"Drop", Drop},
+		{"Cancellation", Cancellation},
+		{"SyncWithMutex", SyncWithMutex},
+		{"SyncWithCounter", SyncWithCounter},
+	}
+
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			defer leakcheck.Check(t)()
+			onFakeClock(t, func(*FakeClock) { p.fn() })
+		})
+	}
+}
+
+func TestRunWorkerWithStopDoesntLeak(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	onFakeClock(t, func(*FakeClock) {
+		ran := make(chan struct{}, 1)
+		stop := RunWorkerWithStop(func(ctx context.Context, _ int, _ time.Time) error {
+			select {
+			case ran <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+
+		// a couple of ticks in, so the stop has a running worker to deal with
+		<-ran
+		<-ran
+		if err := stop(context.Background()); err != nil {
+			t.Fatalf("stop = %v", err)
+		}
+	})
+}
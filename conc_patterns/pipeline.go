@@ -0,0 +1,224 @@
+package concpatterns
+
+import (
+	"context"
+	"sync"
+)
+
+// StageOptions configures how a pipeline stage runs.
+type StageOptions struct {
+	// Workers <= 1 runs the stage sequentially, more fans it out
+	Workers int
+	// Buffer is the size of the stage's output channel. It's how far a stage
+	// may run ahead of the next before back-pressure kicks in.
+	Buffer int
+	// Ordered keeps input order on the output when Workers > 1
+	Ordered bool
+}
+
+// Pipeline chains the fan-out and pooling patterns: Source -> Stage -> ... ->
+// Sink, all under one ctx that is cancelled by the first error.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg   sync.WaitGroup
+	once sync.Once
+	err  error
+}
+
+// Flow is the typed output of a source or stage, to be fed into the next one.
+// Every flow has to end up in a Sink, or the stages feeding it block.
+type Flow[T any] struct {
+	p  *Pipeline
+	ch <-chan T
+}
+
+// NewPipeline creates an empty pipeline bound to ctx.
+func NewPipeline(ctx context.Context) *Pipeline {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Pipeline{ctx: ctx, cancel: cancel}
+}
+
+// Wait blocks until every source, stage and sink has returned and reports the
+// first error, if any.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.cancel()
+	return p.err
+}
+
+func (p *Pipeline) fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+func (p *Pipeline) goFunc(fn func() error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := fn(); err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+// send blocks until v is taken or the pipeline is cancelled.
+func send[T any](ctx context.Context, ch chan<- T, v T) error {
+	select {
+	case ch <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Source starts the pipeline with whatever gen emits. emit fails once the
+// pipeline is cancelled, and gen should return at that point.
+func Source[T any](p *Pipeline, buffer int, gen func(ctx context.Context, emit func(T) error) error) *Flow[T] {
+	out := make(chan T, buffer)
+
+	p.goFunc(func() error {
+		defer close(out)
+		return gen(p.ctx, func(v T) error {
+			return send(p.ctx, out, v)
+		})
+	})
+
+	return &Flow[T]{p: p, ch: out}
+}
+
+// FromSlice is a Source emitting items in order.
+func FromSlice[T any](p *Pipeline, items []T) *Flow[T] {
+	return Source(p, 0, func(_ context.Context, emit func(T) error) error {
+		for _, v := range items {
+			if err := emit(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Stage runs fn over every item of in as configured by opts.
+func Stage[In, Out any](in *Flow[In], opts StageOptions, fn func(context.Context, In) (Out, error)) *Flow[Out] {
+	p := in.p
+	out := make(chan Out, opts.Buffer)
+	workers := max(opts.Workers, 1)
+
+	if opts.Ordered && workers > 1 {
+		stageOrdered(p, in.ch, out, workers, fn)
+		return &Flow[Out]{p: p, ch: out}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		p.goFunc(func() error {
+			defer wg.Done()
+			for v := range in.ch {
+				r, err := fn(p.ctx, v)
+				if err != nil {
+					return err
+				}
+				if err := send(p.ctx, out, r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	// output is closed once every worker has seen the input close
+	p.goFunc(func() error {
+		wg.Wait()
+		close(out)
+		return nil
+	})
+
+	return &Flow[Out]{p: p, ch: out}
+}
+
+type stageResult[T any] struct {
+	v   T
+	err error
+}
+
+type stageJob[In, Out any] struct {
+	v    In
+	slot chan stageResult[Out]
+}
+
+// stageOrdered fans out over workers but hands results on in input order.
+// Every item gets a slot queued in order; the collector waits on the slots one
+// by one, so the number of items in flight stays bounded by the queue.
+func stageOrdered[In, Out any](p *Pipeline, in <-chan In, out chan<- Out, workers int, fn func(context.Context, In) (Out, error)) {
+	jobs := make(chan stageJob[In, Out])
+	order := make(chan chan stageResult[Out], workers+cap(out))
+
+	// dispatcher
+	p.goFunc(func() error {
+		defer close(jobs)
+		defer close(order)
+		for v := range in {
+			slot := make(chan stageResult[Out], 1)
+			if err := send(p.ctx, order, slot); err != nil {
+				return err
+			}
+			if err := send(p.ctx, jobs, stageJob[In, Out]{v: v, slot: slot}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for range workers {
+		p.goFunc(func() error {
+			for j := range jobs {
+				r, err := fn(p.ctx, j.v)
+				if err != nil {
+					// fail now rather than once the collector gets to this
+					// slot, which may be stuck behind slow items before it
+					p.fail(err)
+				}
+				j.slot <- stageResult[Out]{v: r, err: err}
+			}
+			return nil
+		})
+	}
+
+	// collector
+	p.goFunc(func() error {
+		defer close(out)
+		for slot := range order {
+			var res stageResult[Out]
+			select {
+			case res = <-slot:
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+			if res.err != nil {
+				return res.err
+			}
+			if err := send(p.ctx, out, res.v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Sink ends a flow, calling fn for every item that reaches it.
+func Sink[T any](in *Flow[T], fn func(context.Context, T) error) {
+	p := in.p
+	p.goFunc(func() error {
+		for v := range in.ch {
+			if err := fn(p.ctx, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
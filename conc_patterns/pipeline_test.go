@@ -0,0 +1,101 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPipelineStages(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		p := NewPipeline(context.Background())
+		in := FromSlice(p, []int{1, 2, 3, 4, 5, 6, 7, 8})
+		sq := Stage(in, StageOptions{Workers: 4, Ordered: ordered}, func(_ context.Context, v int) (int, error) {
+			time.Sleep(time.Duration(rand.IntN(200)) * time.Microsecond)
+			return v * v, nil
+		})
+
+		var got []int
+		Sink(sq, func(_ context.Context, v int) error {
+			got = append(got, v)
+			return nil
+		})
+		if err := p.Wait(); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []int{1, 4, 9, 16, 25, 36, 49, 64}
+		if !ordered {
+			slices.Sort(got)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("ordered=%v: got %v, want %v", ordered, got, want)
+		}
+	}
+}
+
+func TestPipelineFirstErrorCancels(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	for _, ordered := range []bool{false, true} {
+		p := NewPipeline(context.Background())
+		in := FromSlice(p, []int{0, 1, 2, 3})
+		// the early items only finish once cancelled, so the error on the
+		// last one has to cancel the pipeline by itself
+		out := Stage(in, StageOptions{Workers: 4, Ordered: ordered}, func(ctx context.Context, v int) (int, error) {
+			if v == 3 {
+				return 0, errBoom
+			}
+			<-ctx.Done()
+			return 0, ctx.Err()
+		})
+		Sink(out, func(context.Context, int) error { return nil })
+
+		done := make(chan error, 1)
+		go func() { done <- p.Wait() }()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, errBoom) {
+				t.Fatalf("ordered=%v: Wait() = %v, want %v", ordered, err, errBoom)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("ordered=%v: error didn't cancel the pipeline", ordered)
+		}
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		p := NewPipeline(ctx)
+
+		// endless source
+		in := Source(p, 0, func(ctx context.Context, emit func(int) error) error {
+			for i := 0; ; i++ {
+				if err := emit(i); err != nil {
+					return err
+				}
+			}
+		})
+		out := Stage(in, StageOptions{Workers: 2, Ordered: ordered}, func(_ context.Context, v int) (int, error) {
+			return v, nil
+		})
+
+		seen := 0
+		Sink(out, func(context.Context, int) error {
+			if seen++; seen == 10 {
+				cancel()
+			}
+			return nil
+		})
+
+		if err := p.Wait(); !errors.Is(err, context.Canceled) {
+			t.Fatalf("ordered=%v: Wait() = %v, want context.Canceled", ordered, err)
+		}
+		cancel()
+	}
+}
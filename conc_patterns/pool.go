@@ -0,0 +1,120 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// ErrPoolClosed is returned by Submit once Close has been called.
+var ErrPoolClosed = errors.New("pool closed")
+
+// Result carries the outcome of a single submitted item.
+type Result[In, Out any] struct {
+	In  In
+	Out Out
+	Err error
+}
+
+type job[In any] struct {
+	ctx context.Context
+	in  In
+}
+
+// Pool is the FanOutBounded pattern made reusable: a fixed number of workers
+// ranging over a buffered channel until it's closed.
+type Pool[In, Out any] struct {
+	fn      func(context.Context, In) (Out, error)
+	ch      chan job[In]
+	results chan Result[In, Out]
+
+	mu        sync.RWMutex
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// NewPool starts workers goroutines running fn for every submitted item. A
+// non-positive workers count falls back to runtime.NumCPU().
+func NewPool[In, Out any](workers int, fn func(context.Context, In) (Out, error)) *Pool[In, Out] {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	p := &Pool[In, Out]{
+		fn:      fn,
+		ch:      make(chan job[In], workers), // buf chan for workers
+		results: make(chan Result[In, Out], workers),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	p.wg.Add(workers)
+	for range workers {
+		go func() {
+			defer p.wg.Done()
+			for j := range p.ch {
+				out, err := p.fn(j.ctx, j.in)
+				p.results <- Result[In, Out]{In: j.in, Out: out, Err: err}
+			}
+		}()
+	}
+
+	// results is closed only once every worker has seen the shutdown signal
+	go func() {
+		p.wg.Wait()
+		close(p.results)
+		close(p.done)
+	}()
+
+	return p
+}
+
+// Submit hands in to the next free worker, blocking while the buffer is full.
+// ctx is passed on to the worker func for that item.
+func (p *Pool[In, Out]) Submit(ctx context.Context, in In) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	select {
+	case <-p.quit:
+		return ErrPoolClosed
+	default:
+	}
+
+	select {
+	case p.ch <- job[In]{ctx: ctx, in: in}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.quit:
+		return ErrPoolClosed
+	}
+}
+
+// Results returns the channel results are delivered on. It's closed after
+// Close once all submitted work has been processed, so it must be drained.
+func (p *Pool[In, Out]) Results() <-chan Result[In, Out] {
+	return p.results
+}
+
+// Close sends the shutdown signal: no more submits, workers finish what's
+// buffered and exit. Safe to call more than once.
+func (p *Pool[In, Out]) Close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+
+		// wait out any Submit still blocked on the channel before closing it
+		p.mu.Lock()
+		close(p.ch)
+		p.mu.Unlock()
+	})
+}
+
+// Wait blocks until every worker has exited and Results has been closed.
+func (p *Pool[In, Out]) Wait() {
+	<-p.done
+}
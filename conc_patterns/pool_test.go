@@ -0,0 +1,143 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+func TestPoolResults(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	errOdd := errors.New("odd")
+	p := NewPool(4, func(_ context.Context, n int) (string, error) {
+		if n%2 == 1 {
+			return "", errOdd
+		}
+		return strconv.Itoa(n), nil
+	})
+
+	go func() {
+		defer p.Close()
+		for n := range 100 {
+			if err := p.Submit(context.Background(), n); err != nil {
+				t.Errorf("Submit(%d) = %v", n, err)
+			}
+		}
+	}()
+
+	var ok, failed []int
+	for r := range p.Results() {
+		switch {
+		case errors.Is(r.Err, errOdd):
+			failed = append(failed, r.In)
+		case r.Err != nil:
+			t.Fatalf("result for %d: %v", r.In, r.Err)
+		case r.Out != strconv.Itoa(r.In):
+			t.Fatalf("result for %d = %q", r.In, r.Out)
+		default:
+			ok = append(ok, r.In)
+		}
+	}
+	p.Wait()
+
+	slices.Sort(ok)
+	slices.Sort(failed)
+	if len(ok) != 50 || len(failed) != 50 || ok[0] != 0 || failed[0] != 1 {
+		t.Fatalf("got %d ok and %d failed, want 50 of each", len(ok), len(failed))
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	p := NewPool(1, func(_ context.Context, n int) (int, error) { return n, nil })
+	p.Close()
+	p.Close()
+
+	if err := p.Submit(context.Background(), 1); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("Submit after Close = %v, want ErrPoolClosed", err)
+	}
+	for range p.Results() {
+		t.Fatal("result from a pool nothing was submitted to")
+	}
+	p.Wait()
+}
+
+func TestPoolCloseWakesBlockedSubmit(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	release := make(chan struct{})
+	p := NewPool(1, func(_ context.Context, n int) (int, error) {
+		<-release
+		return n, nil
+	})
+
+	// one on the worker, one in the buffer, and the third has to wait
+	p.Submit(context.Background(), 1)
+	p.Submit(context.Background(), 2)
+	submitted := make(chan error)
+	go func() { submitted <- p.Submit(context.Background(), 3) }()
+
+	closed := make(chan struct{})
+	go func() {
+		// give the third Submit a moment to block
+		time.Sleep(10 * time.Millisecond)
+		p.Close()
+		close(closed)
+	}()
+
+	select {
+	case err := <-submitted:
+		if !errors.Is(err, ErrPoolClosed) {
+			t.Fatalf("blocked Submit = %v, want ErrPoolClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Submit stayed blocked through Close")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close deadlocked with a Submit blocked")
+	}
+
+	// what was already in still gets done
+	close(release)
+	var got []int
+	for r := range p.Results() {
+		got = append(got, r.Out)
+	}
+	p.Wait()
+	if !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("results %v, want [1 2]", got)
+	}
+}
+
+func TestPoolSubmitCtx(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	release := make(chan struct{})
+	p := NewPool(1, func(ctx context.Context, n int) (int, error) {
+		<-release
+		return n, ctx.Err()
+	})
+	p.Submit(context.Background(), 1)
+	p.Submit(context.Background(), 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(ctx, 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Submit on a full pool = %v, want the ctx error", err)
+	}
+
+	close(release)
+	p.Close()
+	for range p.Results() {
+	}
+	p.Wait()
+}
@@ -0,0 +1,209 @@
+package concpatterns
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Job is a unit of work for a PriorityPool.
+type Job[T any] struct {
+	Value    T
+	Priority int       // higher runs first
+	Deadline time.Time // zero for none; past it the job is reported, not run
+}
+
+// PriorityPoolConfig configures a PriorityPool.
+type PriorityPoolConfig[T any] struct {
+	// non-positive falls back to runtime.NumCPU()
+	Workers int
+
+	// every AgingInterval a job spends queued counts as one more priority
+	// level, so a steady stream of high priority work can't starve the rest.
+	// 0 turns aging off.
+	AgingInterval time.Duration
+
+	// OnExpired is called for jobs whose deadline passed while queued
+	OnExpired func(Job[T])
+	// OnError is called with the jobs the handler failed on
+	OnError func(Job[T], error)
+
+	// deadlines and aging are read on Clock, nil means the real clock
+	Clock Clock
+}
+
+type queuedJob[T any] struct {
+	job Job[T]
+	key int64 // bigger runs first
+	seq uint64
+}
+
+type jobHeap[T any] []queuedJob[T]
+
+func (h jobHeap[T]) Len() int { return len(h) }
+func (h jobHeap[T]) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key > h[j].key
+	}
+	return h[i].seq < h[j].seq
+}
+func (h jobHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap[T]) Push(x any)   { *h = append(*h, x.(queuedJob[T])) }
+func (h *jobHeap[T]) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// PriorityPool is Pooling with a priority queue in place of the channel:
+// workers always take the most urgent job that hasn't expired yet.
+type PriorityPool[T any] struct {
+	cfg     PriorityPoolConfig[T]
+	handler func(context.Context, T) error
+	ctx     context.Context
+	start   time.Time
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  jobHeap[T]
+	seq    uint64
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+// NewPriorityPool starts the workers. handler runs under ctx, narrowed to the
+// job's deadline if it has one.
+func NewPriorityPool[T any](ctx context.Context, cfg PriorityPoolConfig[T], handler func(context.Context, T) error) *PriorityPool[T] {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	cfg.Clock = orReal(cfg.Clock)
+
+	p := &PriorityPool[T]{
+		cfg:     cfg,
+		handler: handler,
+		ctx:     ctx,
+		start:   cfg.Clock.Now(),
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	p.wg.Add(cfg.Workers)
+	for range cfg.Workers {
+		go func() {
+			defer p.wg.Done()
+			p.work()
+		}()
+	}
+
+	return p
+}
+
+// Submit queues job.
+func (p *PriorityPool[T]) Submit(job Job[T]) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	// aging compares priority + waited/AgingInterval between jobs at the same
+	// moment, so the current time cancels out and each job gets a fixed key:
+	// priority*AgingInterval - enqueued
+	key := int64(job.Priority)
+	if p.cfg.AgingInterval > 0 {
+		key = agingKey(key, p.cfg.AgingInterval, p.cfg.Clock.Since(p.start))
+	}
+
+	p.seq++
+	heap.Push(&p.queue, queuedJob[T]{job: job, key: key, seq: p.seq})
+	p.cond.Signal()
+	return nil
+}
+
+// agingKey is priority*interval - enqueued, saturating instead of wrapping:
+// priorities too big to age past anything just stay on top (or at the
+// bottom) and order among themselves by when they were queued.
+func agingKey(priority int64, interval, enqueued time.Duration) int64 {
+	d := int64(interval)
+	var key int64
+	switch {
+	case priority > math.MaxInt64/d:
+		key = math.MaxInt64
+	case priority < math.MinInt64/d:
+		key = math.MinInt64
+	default:
+		key = priority * d
+	}
+
+	e := int64(enqueued)
+	switch {
+	case e > 0 && key < math.MinInt64+e:
+		return math.MinInt64
+	case e < 0 && key > math.MaxInt64+e:
+		return math.MaxInt64
+	}
+	return key - e
+}
+
+// Len returns the number of queued jobs.
+func (p *PriorityPool[T]) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queue.Len()
+}
+
+// Close stops accepting jobs. Workers finish what's queued and exit.
+func (p *PriorityPool[T]) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// Wait blocks until every worker has exited after Close.
+func (p *PriorityPool[T]) Wait() {
+	p.wg.Wait()
+}
+
+func (p *PriorityPool[T]) work() {
+	for {
+		p.mu.Lock()
+		for p.queue.Len() == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.queue.Len() == 0 {
+			p.mu.Unlock()
+			return
+		}
+		job := heap.Pop(&p.queue).(queuedJob[T]).job
+		p.mu.Unlock()
+
+		if !job.Deadline.IsZero() && !p.cfg.Clock.Now().Before(job.Deadline) {
+			if p.cfg.OnExpired != nil {
+				p.cfg.OnExpired(job)
+			}
+			continue
+		}
+
+		if err := p.run(job); err != nil && p.cfg.OnError != nil {
+			p.cfg.OnError(job, err)
+		}
+	}
+}
+
+func (p *PriorityPool[T]) run(job Job[T]) error {
+	ctx := p.ctx
+	if !job.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = withDeadline(ctx, p.cfg.Clock, job.Deadline)
+		defer cancel()
+	}
+	return p.handler(ctx, job.Value)
+}
@@ -0,0 +1,210 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+// blockedPool starts a one-worker pool and parks the worker on a "block" job,
+// so everything submitted afterwards queues up. The returned func lets the
+// worker go; ran lists the values handled, in order.
+func blockedPool(t *testing.T, cfg PriorityPoolConfig[string]) (p *PriorityPool[string], release func(), ran func() []string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var order []string
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+
+	cfg.Workers = 1
+	p = NewPriorityPool(context.Background(), cfg, func(_ context.Context, v string) error {
+		if v == "block" {
+			close(started)
+			<-unblock
+			return nil
+		}
+		mu.Lock()
+		order = append(order, v)
+		mu.Unlock()
+		return nil
+	})
+
+	if err := p.Submit(Job[string]{Value: "block"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ran = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(order)
+	}
+	return p, func() { close(unblock) }, ran
+}
+
+func TestPriorityPoolOrder(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	p, release, ran := blockedPool(t, PriorityPoolConfig[string]{})
+	for _, j := range []Job[string]{
+		{Value: "low", Priority: 1},
+		{Value: "high", Priority: 9},
+		{Value: "mid-1", Priority: 5},
+		{Value: "mid-2", Priority: 5},
+	} {
+		if err := p.Submit(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	release()
+	p.Close()
+	p.Wait()
+
+	// equal priorities keep submission order
+	want := []string{"high", "mid-1", "mid-2", "low"}
+	if got := ran(); !slices.Equal(got, want) {
+		t.Fatalf("ran %v, want %v", got, want)
+	}
+}
+
+func TestPriorityPoolExpired(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	var expired []string
+	p, release, ran := blockedPool(t, PriorityPoolConfig[string]{
+		Clock:     fc,
+		OnExpired: func(j Job[string]) { expired = append(expired, j.Value) },
+	})
+
+	p.Submit(Job[string]{Value: "stale", Priority: 9, Deadline: epoch.Add(time.Second)})
+	p.Submit(Job[string]{Value: "fresh", Deadline: epoch.Add(time.Minute)})
+	p.Submit(Job[string]{Value: "whenever"})
+	fc.Advance(time.Second)
+
+	release()
+	p.Close()
+	p.Wait()
+
+	if !slices.Equal(expired, []string{"stale"}) {
+		t.Fatalf("expired %v, want [stale]", expired)
+	}
+	if got, want := ran(), []string{"fresh", "whenever"}; !slices.Equal(got, want) {
+		t.Fatalf("ran %v, want %v", got, want)
+	}
+}
+
+func TestPriorityPoolAging(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	p, release, ran := blockedPool(t, PriorityPoolConfig[string]{
+		Clock:         fc,
+		AgingInterval: time.Second,
+	})
+
+	p.Submit(Job[string]{Value: "old", Priority: 0})
+	fc.Advance(3 * time.Second)
+	// 3s of waiting makes old worth a priority 3 submitted now
+	p.Submit(Job[string]{Value: "new-2", Priority: 2})
+	p.Submit(Job[string]{Value: "new-5", Priority: 5})
+
+	release()
+	p.Close()
+	p.Wait()
+
+	want := []string{"new-5", "old", "new-2"}
+	if got := ran(); !slices.Equal(got, want) {
+		t.Fatalf("ran %v, want %v", got, want)
+	}
+}
+
+func TestPriorityPoolAgingExtremePriorities(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	p, release, ran := blockedPool(t, PriorityPoolConfig[string]{
+		Clock:         fc,
+		AgingInterval: time.Hour,
+	})
+
+	fc.Advance(time.Minute)
+	// math.MaxInt*time.Hour would wrap negative and run these last
+	p.Submit(Job[string]{Value: "min", Priority: math.MinInt})
+	p.Submit(Job[string]{Value: "max-1", Priority: math.MaxInt})
+	p.Submit(Job[string]{Value: "zero", Priority: 0})
+	fc.Advance(time.Minute)
+	p.Submit(Job[string]{Value: "max-2", Priority: math.MaxInt})
+	p.Submit(Job[string]{Value: "big", Priority: math.MaxInt / 2})
+
+	release()
+	p.Close()
+	p.Wait()
+
+	want := []string{"max-1", "max-2", "big", "zero", "min"}
+	if got := ran(); !slices.Equal(got, want) {
+		t.Fatalf("ran %v, want %v", got, want)
+	}
+}
+
+func TestAgingKey(t *testing.T) {
+	tests := []struct {
+		priority           int64
+		interval, enqueued time.Duration
+		want               int64
+	}{
+		{3, time.Second, 2 * time.Second, int64(time.Second)},
+		{-3, time.Second, 0, -3 * int64(time.Second)},
+		{math.MaxInt64, time.Second, 0, math.MaxInt64},
+		{math.MaxInt64, time.Second, time.Second, math.MaxInt64 - int64(time.Second)},
+		{math.MinInt64 / 2, time.Second, 0, math.MinInt64},
+		{math.MinInt64, time.Second, time.Second, math.MinInt64},
+		{0, time.Second, math.MinInt64, math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		if got := agingKey(tt.priority, tt.interval, tt.enqueued); got != tt.want {
+			t.Errorf("agingKey(%d, %v, %v) = %d, want %d", tt.priority, tt.interval, tt.enqueued, got, tt.want)
+		}
+	}
+}
+
+func TestPriorityPoolErrors(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	errBad := errors.New("bad job")
+	var failed []string
+	p := NewPriorityPool(context.Background(), PriorityPoolConfig[string]{
+		Workers: 1,
+		OnError: func(j Job[string], err error) {
+			if errors.Is(err, errBad) {
+				failed = append(failed, j.Value)
+			}
+		},
+	}, func(_ context.Context, v string) error {
+		if v == "bad" {
+			return errBad
+		}
+		return nil
+	})
+
+	p.Submit(Job[string]{Value: "good"})
+	p.Submit(Job[string]{Value: "bad"})
+	p.Close()
+	p.Wait()
+
+	if !slices.Equal(failed, []string{"bad"}) {
+		t.Fatalf("OnError saw %v, want [bad]", failed)
+	}
+	if err := p.Submit(Job[string]{Value: "late"}); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("Submit after Close = %v, want ErrPoolClosed", err)
+	}
+}
@@ -0,0 +1,308 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by Wait when ctx would be done before the limiter
+// lets the caller through, so there's no point in waiting.
+var ErrRateLimited = errors.New("rate limited: wait exceeds context deadline")
+
+// Limiter limits how often something may happen over time. TokenBucket and
+// SlidingWindow implement it.
+type Limiter interface {
+	// Allow reports whether one event may happen now, using it up if so.
+	Allow() bool
+	// Reserve books the next free slot, now or in the future.
+	Reserve() *Reservation
+	// Wait blocks until one event may happen or ctx is done.
+	Wait(ctx context.Context) error
+}
+
+// Reservation is a slot booked with Reserve.
+type Reservation struct {
+	ok     bool
+	at     time.Time
+	clock  Clock
+	cancel func()
+	once   sync.Once
+}
+
+// OK reports whether the reservation can ever be honoured.
+func (r *Reservation) OK() bool { return r.ok }
+
+// Delay is how long to wait before acting on the reservation.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return time.Duration(1<<63 - 1)
+	}
+	return max(r.at.Sub(r.clock.Now()), 0)
+}
+
+// Cancel hands the slot back if it hasn't come up yet.
+func (r *Reservation) Cancel() {
+	if !r.ok || !r.clock.Now().Before(r.at) {
+		return
+	}
+	r.once.Do(r.cancel)
+}
+
+// waitReservation is the Wait shared by the limiters.
+func waitReservation(ctx context.Context, r *Reservation) error {
+	if !r.ok {
+		return ErrRateLimited
+	}
+
+	d := r.Delay()
+	if d == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(r.at) {
+		r.Cancel()
+		return ErrRateLimited
+	}
+	if !sleepCtx(ctx, r.clock, d) {
+		r.Cancel()
+		return ctx.Err()
+	}
+	return nil
+}
+
+// LimiterOptions configures the clock limiters tell time with.
+type LimiterOptions struct {
+	// nil means the real clock
+	Clock Clock
+}
+
+// TokenBucket refills at rate tokens per second up to burst, one token per
+// event.
+type TokenBucket struct {
+	clock  Clock
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a bucket that starts full.
+func NewTokenBucket(rate float64, burst int, opts LimiterOptions) *TokenBucket {
+	c := orReal(opts.Clock)
+	return &TokenBucket{
+		clock:  c,
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   c.Now(),
+	}
+}
+
+// refill tops the bucket up for the time since the last call. Must be called
+// with mu held.
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(float64(b.burst), b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(b.clock.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *TokenBucket) Reserve() *Reservation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.refill(now)
+
+	if b.tokens < 1 && b.rate <= 0 {
+		return &Reservation{clock: b.clock}
+	}
+
+	// going into debt is what books the future slot
+	b.tokens--
+	at := now
+	if b.tokens < 0 {
+		at = now.Add(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+	}
+
+	return &Reservation{
+		ok:    true,
+		at:    at,
+		clock: b.clock,
+		cancel: func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.tokens = min(float64(b.burst), b.tokens+1)
+		},
+	}
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	return waitReservation(ctx, b.Reserve())
+}
+
+// SlidingWindow allows at most limit events in any window long stretch of
+// time. It keeps a timestamp per event, so it's exact but meant for modest
+// limits.
+type SlidingWindow struct {
+	clock  Clock
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	stamps []time.Time // ascending, some may be reservations in the future
+}
+
+// NewSlidingWindow creates a window allowing bursts of up to limit events.
+func NewSlidingWindow(limit int, window time.Duration, opts LimiterOptions) *SlidingWindow {
+	return &SlidingWindow{clock: orReal(opts.Clock), limit: limit, window: window}
+}
+
+// prune forgets events that have slid out of the window. Must be called with
+// mu held.
+func (w *SlidingWindow) prune(now time.Time) {
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.stamps) && !w.stamps[i].After(cutoff) {
+		i++
+	}
+	w.stamps = w.stamps[i:]
+}
+
+func (w *SlidingWindow) Allow() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.clock.Now()
+	w.prune(now)
+	if len(w.stamps) >= w.limit {
+		return false
+	}
+	w.stamps = append(w.stamps, now)
+	return true
+}
+
+func (w *SlidingWindow) Reserve() *Reservation {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.limit <= 0 {
+		return &Reservation{clock: w.clock}
+	}
+
+	now := w.clock.Now()
+	w.prune(now)
+
+	// the slot frees up once the limit-th most recent event slides out
+	at := now
+	if n := len(w.stamps); n >= w.limit {
+		at = w.stamps[n-w.limit].Add(w.window)
+	}
+	w.stamps = append(w.stamps, at)
+
+	return &Reservation{
+		ok:    true,
+		at:    at,
+		clock: w.clock,
+		cancel: func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			for i, s := range w.stamps {
+				if s.Equal(at) {
+					w.stamps = append(w.stamps[:i], w.stamps[i+1:]...)
+					return
+				}
+			}
+		},
+	}
+}
+
+func (w *SlidingWindow) Wait(ctx context.Context) error {
+	return waitReservation(ctx, w.Reserve())
+}
+
+type keyedEntry struct {
+	l        Limiter
+	lastUsed time.Time
+}
+
+// KeyedLimiter keeps a Limiter per key, e.g. per client, and forgets the ones
+// that have been idle for longer than idle.
+type KeyedLimiter[K comparable] struct {
+	clock      Clock
+	mu         sync.Mutex
+	newLimiter func() Limiter
+	idle       time.Duration
+	entries    map[K]*keyedEntry
+	lastSweep  time.Time
+}
+
+// NewKeyedLimiter creates an empty keyed limiter; newLimiter is called the
+// first time a key is seen. opts.Clock times the idle eviction; the limiters
+// newLimiter makes have clocks of their own.
+func NewKeyedLimiter[K comparable](idle time.Duration, newLimiter func() Limiter, opts LimiterOptions) *KeyedLimiter[K] {
+	c := orReal(opts.Clock)
+	return &KeyedLimiter[K]{
+		clock:      c,
+		newLimiter: newLimiter,
+		idle:       idle,
+		entries:    make(map[K]*keyedEntry),
+		lastSweep:  c.Now(),
+	}
+}
+
+// Get returns the limiter for key, creating it if needed.
+func (kl *KeyedLimiter[K]) Get(key K) Limiter {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	now := kl.clock.Now()
+	// sweep lazily, at most once per idle period, instead of running a
+	// janitor goroutine that would need stopping
+	if now.Sub(kl.lastSweep) > kl.idle {
+		for k, e := range kl.entries {
+			if now.Sub(e.lastUsed) > kl.idle {
+				delete(kl.entries, k)
+			}
+		}
+		kl.lastSweep = now
+	}
+
+	e, ok := kl.entries[key]
+	if !ok {
+		e = &keyedEntry{l: kl.newLimiter()}
+		kl.entries[key] = e
+	}
+	e.lastUsed = now
+	return e.l
+}
+
+// Allow is Get(key).Allow().
+func (kl *KeyedLimiter[K]) Allow(key K) bool {
+	return kl.Get(key).Allow()
+}
+
+// Wait is Get(key).Wait(ctx).
+func (kl *KeyedLimiter[K]) Wait(ctx context.Context, key K) error {
+	return kl.Get(key).Wait(ctx)
+}
+
+// Len returns how many keys are currently tracked.
+func (kl *KeyedLimiter[K]) Len() int {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return len(kl.entries)
+}
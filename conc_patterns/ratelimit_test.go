@@ -0,0 +1,92 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	opts := LimiterOptions{Clock: fc}
+
+	b := NewTokenBucket(2, 2, opts)
+	if !b.Allow() || !b.Allow() || b.Allow() {
+		t.Fatal("want exactly the burst of 2 allowed up front")
+	}
+
+	fc.Advance(500 * time.Millisecond)
+	if !b.Allow() || b.Allow() {
+		t.Fatal("want one token back after half a second at 2/s")
+	}
+
+	r := b.Reserve()
+	if d := r.Delay(); d != 500*time.Millisecond {
+		t.Fatalf("Delay() = %v, want 500ms", d)
+	}
+	r.Cancel()
+	fc.Advance(500 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("cancelled reservation didn't hand its token back")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	opts := LimiterOptions{Clock: fc}
+
+	w := NewSlidingWindow(2, time.Second, opts)
+	w.Allow()
+	fc.Advance(600 * time.Millisecond)
+	w.Allow()
+	if w.Allow() {
+		t.Fatal("third event in the window allowed")
+	}
+
+	// the first event slides out at 1s, the second at 1.6s
+	fc.Advance(400 * time.Millisecond)
+	if !w.Allow() || w.Allow() {
+		t.Fatal("want one slot back once the first event slid out")
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	opts := LimiterOptions{Clock: fc}
+
+	b := NewTokenBucket(1, 1, opts)
+	b.Allow()
+
+	done := make(chan error, 1)
+	go func() { done <- b.Wait(context.Background()) }()
+
+	fc.BlockUntil(1)
+	fc.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// a deadline that comes before the slot fails fast, without waiting
+	ctx, cancel := withTimeout(context.Background(), fc, 100*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Wait() = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestKeyedLimiterEvictsIdle(t *testing.T) {
+	fc := NewFakeClock(epoch)
+	opts := LimiterOptions{Clock: fc}
+
+	kl := NewKeyedLimiter[string](time.Minute, func() Limiter { return NewTokenBucket(1, 1, opts) }, opts)
+	if !kl.Allow("a") || kl.Allow("a") || !kl.Allow("b") {
+		t.Fatal("keys don't have a limiter each")
+	}
+
+	fc.Advance(2 * time.Minute)
+	kl.Allow("c")
+	if n := kl.Len(); n != 1 {
+		t.Fatalf("Len() = %d after a and b went idle, want 1", n)
+	}
+}
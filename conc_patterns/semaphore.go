@@ -0,0 +1,174 @@
+package concpatterns
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrSemaphoreTooLarge is returned when asking for more than the
+	// semaphore's total size, which could never be granted.
+	ErrSemaphoreTooLarge = errors.New("semaphore: acquire exceeds size")
+	// ErrSemaphoreWeight is returned when asking for a weight below 1.
+	ErrSemaphoreWeight = errors.New("semaphore: weight must be positive")
+)
+
+// SemaphoreStats is a point in time view of a Semaphore. Release isn't tied
+// to a particular Acquire, so what's held is reported as a total weight rather
+// than per holder.
+type SemaphoreStats struct {
+	Size    int64 // total weight
+	Held    int64 // weight currently acquired
+	Waiters int   // callers queued in Acquire
+}
+
+type semWaiter struct {
+	n     int64
+	ready chan struct{} // closed once the weight has been granted
+}
+
+// Semaphore is a weighted semaphore. Waiters are served strictly FIFO, so a
+// large request at the head of the queue holds back smaller ones behind it
+// rather than being starved by them.
+type Semaphore struct {
+	size    int64
+	mu      sync.Mutex
+	cur     int64
+	waiters list.List
+}
+
+// NewSemaphore creates a semaphore with a total weight of n.
+func NewSemaphore(n int64) *Semaphore {
+	return &Semaphore{size: n}
+}
+
+// Acquire blocks until n can be granted or ctx is done. On failure nothing is
+// held and ctx.Err() is returned.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	done := ctx.Done()
+
+	s.mu.Lock()
+	select {
+	case <-done:
+		// don't hand out weight to a caller that's already gone
+		s.mu.Unlock()
+		return ctx.Err()
+	default:
+	}
+
+	if n <= 0 {
+		s.mu.Unlock()
+		return ErrSemaphoreWeight
+	}
+	if n > s.size {
+		s.mu.Unlock()
+		return ErrSemaphoreTooLarge
+	}
+
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.grant(n)
+		s.mu.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(semWaiter{n: n, ready: ready})
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+
+	case <-done:
+		s.mu.Lock()
+		select {
+		case <-ready:
+			// granted just as ctx was cancelled: give it back
+			s.release(n)
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// the head leaving may let the ones behind it in
+			if isFront && s.size > s.cur {
+				s.notifyWaiters()
+			}
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire takes n without blocking and reports whether it succeeded. It
+// never jumps the queue of waiting callers.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n > 0 && s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.grant(n)
+		return true
+	}
+	return false
+}
+
+// Release gives back n, which needn't match a single Acquire. Releasing more
+// than is held, or a weight below 1, panics.
+func (s *Semaphore) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n <= 0 {
+		panic("semaphore: release of non-positive weight")
+	}
+	if n > s.cur {
+		panic("semaphore: released more than held")
+	}
+	s.release(n)
+}
+
+// Stats returns the current size, held weight and waiters.
+func (s *Semaphore) Stats() SemaphoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SemaphoreStats{
+		Size:    s.size,
+		Held:    s.cur,
+		Waiters: s.waiters.Len(),
+	}
+}
+
+// notifyWaiters grants weight to waiters from the front of the queue for as
+// long as the next one fits. Must be called with mu held.
+func (s *Semaphore) notifyWaiters() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+
+		w := front.Value.(semWaiter)
+		if s.size-s.cur < w.n {
+			// stop here instead of letting smaller ones past: that's what keeps
+			// big requests from starving
+			return
+		}
+
+		s.grant(w.n)
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+// grant hands out n. Must be called with mu held.
+func (s *Semaphore) grant(n int64) {
+	s.cur += n
+}
+
+// release takes n back and lets waiters in. Must be called with mu held.
+func (s *Semaphore) release(n int64) {
+	s.cur -= n
+	s.notifyWaiters()
+}
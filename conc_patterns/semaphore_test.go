@@ -0,0 +1,92 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestSemaphoreStats(t *testing.T) {
+	s := NewSemaphore(4)
+	ctx := context.Background()
+
+	s.Acquire(ctx, 3)
+	s.Acquire(ctx, 1)
+	if st := s.Stats(); st != (SemaphoreStats{Size: 4, Held: 4}) {
+		t.Fatalf("stats = %+v, want 4 of 4 held", st)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Acquire(ctx, 2) }()
+	for s.Stats().Waiters == 0 {
+		runtime.Gosched()
+	}
+	if st := s.Stats(); st.Held != 4 || st.Waiters != 1 {
+		t.Fatalf("stats = %+v, want 4 held and 1 waiter", st)
+	}
+
+	// released weight needn't match what was acquired
+	s.Release(1)
+	if st := s.Stats(); st.Held != 3 || st.Waiters != 1 {
+		t.Fatalf("stats = %+v, want 3 held and 1 waiter", st)
+	}
+	s.Release(1)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Stats(); st.Held != 4 || st.Waiters != 0 {
+		t.Fatalf("stats = %+v, want 4 held once the waiter got in", st)
+	}
+
+	s.Release(4)
+	if st := s.Stats(); st.Held != 0 {
+		t.Fatalf("held = %d, want 0", st.Held)
+	}
+}
+
+func TestSemaphoreRejectsNonPositiveWeight(t *testing.T) {
+	s := NewSemaphore(2)
+
+	for _, n := range []int64{0, -1} {
+		if err := s.Acquire(context.Background(), n); !errors.Is(err, ErrSemaphoreWeight) {
+			t.Errorf("Acquire(%d) = %v, want ErrSemaphoreWeight", n, err)
+		}
+		if s.TryAcquire(n) {
+			t.Errorf("TryAcquire(%d) succeeded", n)
+		}
+	}
+	if st := s.Stats(); st.Held != 0 || st.Size != 2 {
+		t.Fatalf("stats = %+v, want nothing held out of 2", st)
+	}
+
+	s.TryAcquire(1)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Release(-1) didn't panic")
+		}
+	}()
+	s.Release(-1)
+}
+
+func TestSemaphoreFIFO(t *testing.T) {
+	s := NewSemaphore(2)
+	ctx := context.Background()
+	s.Acquire(ctx, 2)
+
+	big := make(chan error, 1)
+	go func() { big <- s.Acquire(ctx, 2) }()
+	for s.Stats().Waiters == 0 {
+		runtime.Gosched()
+	}
+
+	// the waiting 2 holds back the 1 even though it would fit
+	s.Release(1)
+	if s.TryAcquire(1) {
+		t.Fatal("TryAcquire jumped the queue")
+	}
+	s.Release(1)
+	if err := <-big; err != nil {
+		t.Fatal(err)
+	}
+}
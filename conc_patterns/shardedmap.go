@@ -0,0 +1,266 @@
+package concpatterns
+
+import (
+	"hash/maphash"
+	"math"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// ShardedMapOptions configures a ShardedMap. Zero values get sane defaults.
+type ShardedMapOptions[K comparable] struct {
+	// Shards is rounded up to a power of two, defaults to 4 per P
+	Shards int
+	// Hash spreads keys over shards, and keys that are == must hash the same.
+	// The default handles any comparable key type, including structs, arrays
+	// and interfaces; a custom one is only worth it for speed.
+	Hash func(K) uint64
+}
+
+type mapShard[K comparable, V any] struct {
+	mu sync.RWMutex
+	m  map[K]V
+}
+
+// ShardedMap is a map guarded by one RWMutex per shard, so readers share a
+// lock and writers to different shards don't block each other at all.
+type ShardedMap[K comparable, V any] struct {
+	shards []mapShard[K, V]
+	mask   uint64
+	hash   func(K) uint64
+}
+
+// NewShardedMap creates an empty map.
+func NewShardedMap[K comparable, V any](opts ShardedMapOptions[K]) *ShardedMap[K, V] {
+	if opts.Shards <= 0 {
+		opts.Shards = 4 * runtime.GOMAXPROCS(0)
+	}
+	n := 1
+	for n < opts.Shards {
+		n <<= 1
+	}
+
+	if opts.Hash == nil {
+		opts.Hash = defaultHash[K](maphash.MakeSeed())
+	}
+
+	sm := &ShardedMap[K, V]{
+		shards: make([]mapShard[K, V], n),
+		mask:   uint64(n - 1),
+		hash:   opts.Hash,
+	}
+	for i := range sm.shards {
+		sm.shards[i].m = make(map[K]V)
+	}
+	return sm
+}
+
+func (sm *ShardedMap[K, V]) shard(k K) *mapShard[K, V] {
+	return &sm.shards[sm.hash(k)&sm.mask]
+}
+
+// Get returns the value for k, if any.
+func (sm *ShardedMap[K, V]) Get(k K) (V, bool) {
+	s := sm.shard(k)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.m[k]
+	return v, ok
+}
+
+// Set stores v under k.
+func (sm *ShardedMap[K, V]) Set(k K, v V) {
+	s := sm.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.m[k] = v
+}
+
+// Delete removes k.
+func (sm *ShardedMap[K, V]) Delete(k K) {
+	s := sm.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.m, k)
+}
+
+// Compute atomically replaces the value under k with whatever fn returns.
+// fn gets the old value and whether there was one; returning keep == false
+// deletes the key. fn runs with the shard locked, so keep it short and don't
+// touch the map from inside it.
+func (sm *ShardedMap[K, V]) Compute(k K, fn func(old V, ok bool) (v V, keep bool)) (V, bool) {
+	s := sm.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.m[k]
+	v, keep := fn(old, ok)
+	if !keep {
+		delete(s.m, k)
+		var zero V
+		return zero, false
+	}
+	s.m[k] = v
+	return v, true
+}
+
+// Len returns the number of keys.
+func (sm *ShardedMap[K, V]) Len() int {
+	n := 0
+	for i := range sm.shards {
+		s := &sm.shards[i]
+		s.mu.RLock()
+		n += len(s.m)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Range calls fn for every key until it returns false. Each shard is copied
+// under its read lock and fn runs on the copy, so fn may freely use the map.
+// Writes made while ranging may or may not be seen.
+func (sm *ShardedMap[K, V]) Range(fn func(K, V) bool) {
+	type entry struct {
+		k K
+		v V
+	}
+
+	var buf []entry
+	for i := range sm.shards {
+		s := &sm.shards[i]
+
+		s.mu.RLock()
+		buf = buf[:0]
+		for k, v := range s.m {
+			buf = append(buf, entry{k, v})
+		}
+		s.mu.RUnlock()
+
+		for _, e := range buf {
+			if !fn(e.k, e.v) {
+				return
+			}
+		}
+	}
+}
+
+// Snapshot copies the whole map. Each shard is consistent with itself, but
+// not necessarily with the others.
+func (sm *ShardedMap[K, V]) Snapshot() map[K]V {
+	out := make(map[K]V, sm.Len())
+	for i := range sm.shards {
+		s := &sm.shards[i]
+		s.mu.RLock()
+		for k, v := range s.m {
+			out[k] = v
+		}
+		s.mu.RUnlock()
+	}
+	return out
+}
+
+// defaultHash hashes the common builtin key types directly and everything
+// else by walking it through reflect: structs and arrays field by field,
+// interfaces by their dynamic value. Keys that are == hash the same, and
+// distinct ones may collide, which only costs a shared shard.
+func defaultHash[K comparable](seed maphash.Seed) func(K) uint64 {
+	return func(k K) uint64 {
+		switch k := any(k).(type) {
+		case string:
+			return maphash.String(seed, k)
+		case int:
+			return mix64(uint64(k))
+		case int32:
+			return mix64(uint64(k))
+		case int64:
+			return mix64(uint64(k))
+		case uint:
+			return mix64(uint64(k))
+		case uint32:
+			return mix64(uint64(k))
+		case uint64:
+			return mix64(k)
+		case float64:
+			return floatHash(k)
+		default:
+			return reflectHash(seed, reflect.ValueOf(k))
+		}
+	}
+}
+
+// reflectHash hashes any comparable value. A nil interface, and a dynamic
+// value that can't be compared at all, hash to 0; the map itself panics on
+// the latter.
+func reflectHash(seed maphash.Seed, v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.String:
+		return maphash.String(seed, v.String())
+	case reflect.Bool:
+		return boolHash(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return mix64(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return mix64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return floatHash(v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return combineHash(floatHash(real(c)), floatHash(imag(c)))
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Chan:
+		return mix64(uint64(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return reflectHash(seed, v.Elem())
+	case reflect.Struct:
+		var h uint64
+		for i := range v.NumField() {
+			h = combineHash(h, reflectHash(seed, v.Field(i)))
+		}
+		return h
+	case reflect.Array:
+		var h uint64
+		for i := range v.Len() {
+			h = combineHash(h, reflectHash(seed, v.Index(i)))
+		}
+		return h
+	default:
+		return 0
+	}
+}
+
+// combineHash folds x into h, so the same values in a different order
+// usually land elsewhere.
+func combineHash(h, x uint64) uint64 {
+	return mix64(h*31 + x)
+}
+
+func boolHash(b bool) uint64 {
+	if b {
+		return mix64(1)
+	}
+	return mix64(0)
+}
+
+// floatHash hashes 0 and -0, which are ==, the same.
+func floatHash(f float64) uint64 {
+	if f == 0 {
+		return 0
+	}
+	return mix64(math.Float64bits(f))
+}
+
+// mix64 is the splitmix64 finalizer, so sequential ints spread over shards.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
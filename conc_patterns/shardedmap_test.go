@@ -0,0 +1,221 @@
+package concpatterns
+
+import (
+	"hash/maphash"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"testing"
+)
+
+func TestShardedMapDefaultHashEqualKeys(t *testing.T) {
+	negZero := math.Copysign(0, -1)
+
+	m := NewShardedMap[float64, string](ShardedMapOptions[float64]{Shards: 64})
+	m.Set(0, "zero")
+	if v, ok := m.Get(negZero); !ok || v != "zero" {
+		t.Fatalf("Get(-0) = %q, %v; want the value set under 0", v, ok)
+	}
+
+	type ID string
+	ids := NewShardedMap[ID, int](ShardedMapOptions[ID]{Shards: 64})
+	ids.Set("a", 1)
+	if v, ok := ids.Get("a"); !ok || v != 1 {
+		t.Fatalf("named string key: Get = %d, %v", v, ok)
+	}
+}
+
+func TestShardedMapDefaultHashCompositeKeys(t *testing.T) {
+	negZero := math.Copysign(0, -1)
+
+	type point struct {
+		name string
+		x, y float64
+	}
+	type tagged struct {
+		tag any
+		at  [2]point
+	}
+
+	t.Run("struct", func(t *testing.T) {
+		m := NewShardedMap[point, int](ShardedMapOptions[point]{Shards: 64})
+		m.Set(point{"o", 0, 1}, 1)
+		if v, ok := m.Get(point{"o", negZero, 1}); !ok || v != 1 {
+			t.Fatalf("Get with a -0 field = %d, %v; want the value set under 0", v, ok)
+		}
+		if _, ok := m.Get(point{"o", 1, 0}); ok {
+			t.Fatal("swapped fields found the same key")
+		}
+	})
+
+	t.Run("array", func(t *testing.T) {
+		m := NewShardedMap[[3]int, int](ShardedMapOptions[[3]int]{Shards: 64})
+		m.Set([3]int{1, 2, 3}, 1)
+		if v, ok := m.Get([3]int{1, 2, 3}); !ok || v != 1 {
+			t.Fatalf("Get = %d, %v", v, ok)
+		}
+	})
+
+	t.Run("interface", func(t *testing.T) {
+		m := NewShardedMap[any, int](ShardedMapOptions[any]{Shards: 64})
+		keys := []any{nil, 1, "1", int8(1), point{"p", 1, 2}, tagged{tag: "t"}, [2]bool{true}}
+		for i, k := range keys {
+			m.Set(k, i)
+		}
+		for i, k := range keys {
+			if v, ok := m.Get(k); !ok || v != i {
+				t.Fatalf("Get(%#v) = %d, %v; want %d", k, v, ok, i)
+			}
+		}
+		if m.Len() != len(keys) {
+			t.Fatalf("Len = %d, want %d", m.Len(), len(keys))
+		}
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		h := defaultHash[tagged](maphash.MakeSeed())
+		a := tagged{tag: 0.0, at: [2]point{{"a", 0, 0}, {"b", 1, 1}}}
+		b := tagged{tag: negZero, at: [2]point{{"a", negZero, 0}, {"b", 1, 1}}}
+		if a != b || h(a) != h(b) {
+			t.Fatalf("equal keys hash differently: %x, %x", h(a), h(b))
+		}
+	})
+
+	t.Run("spread", func(t *testing.T) {
+		h := defaultHash[point](maphash.MakeSeed())
+		seen := make(map[uint64]bool)
+		for i := range 100 {
+			seen[h(point{"p", float64(i), 0})&63] = true
+		}
+		if len(seen) < 32 {
+			t.Fatalf("100 struct keys only hit %d of 64 shards", len(seen))
+		}
+	})
+}
+
+func TestShardedMapCompute(t *testing.T) {
+	m := NewShardedMap[string, int](ShardedMapOptions[string]{})
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				m.Compute("n", func(old int, _ bool) (int, bool) { return old + 1, true })
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := m.Get("n"); v != 8000 {
+		t.Fatalf("n = %d, want 8000", v)
+	}
+
+	if v, ok := m.Compute("n", func(int, bool) (int, bool) { return 0, false }); ok || v != 0 {
+		t.Fatalf("delete via Compute returned %d, %v", v, ok)
+	}
+	if _, ok := m.Get("n"); ok {
+		t.Fatal("n still there after Compute dropped it")
+	}
+}
+
+func TestShardedMapRangeAndSnapshot(t *testing.T) {
+	m := NewShardedMap[int, int](ShardedMapOptions[int]{Shards: 8})
+	for i := range 100 {
+		m.Set(i, i*i)
+	}
+
+	seen := map[int]int{}
+	m.Range(func(k, v int) bool {
+		if k >= 1000 {
+			// written while ranging, may or may not be seen
+			return true
+		}
+		seen[k] = v
+		// fn runs on a copy, so writing to the map from it is fine
+		m.Set(k+1000, v)
+		return true
+	})
+	if len(seen) != 100 {
+		t.Fatalf("Range saw %d of the 100 keys", len(seen))
+	}
+	for k, v := range seen {
+		if v != k*k {
+			t.Fatalf("Range: %d -> %d", k, v)
+		}
+	}
+
+	n := 0
+	m.Range(func(int, int) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("Range went on for %d calls after returning false at 10", n)
+	}
+
+	snap := m.Snapshot()
+	if len(snap) != 200 || len(snap) != m.Len() {
+		t.Fatalf("Snapshot has %d keys, Len %d, want 200", len(snap), m.Len())
+	}
+	m.Delete(0)
+	if _, ok := snap[0]; !ok {
+		t.Fatal("Snapshot isn't a copy")
+	}
+}
+
+// read-heavy: 1 write in 100, write-heavy: 1 in 2
+var mapMixes = []struct {
+	name       string
+	writeEvery int
+}{
+	{"ReadHeavy", 100},
+	{"WriteHeavy", 2},
+}
+
+const benchKeys = 1 << 12
+
+func BenchmarkShardedMap(b *testing.B) {
+	for _, mix := range mapMixes {
+		b.Run(mix.name, func(b *testing.B) {
+			m := NewShardedMap[int, int](ShardedMapOptions[int]{})
+			for i := range benchKeys {
+				m.Set(i, i)
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.IntN(benchKeys)
+				for pb.Next() {
+					i = (i + 1) % benchKeys
+					if i%mix.writeEvery == 0 {
+						m.Set(i, i)
+					} else {
+						m.Get(i)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSyncMap(b *testing.B) {
+	for _, mix := range mapMixes {
+		b.Run(mix.name, func(b *testing.B) {
+			var m sync.Map
+			for i := range benchKeys {
+				m.Store(i, i)
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.IntN(benchKeys)
+				for pb.Next() {
+					i = (i + 1) % benchKeys
+					if i%mix.writeEvery == 0 {
+						m.Store(i, i)
+					} else {
+						m.Load(i)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package concpatterns
+
+import (
+	"context"
+	"sync"
+)
+
+type flight[V any] struct {
+	done   chan struct{}
+	v      V
+	err    error
+	cancel context.CancelFunc
+
+	// guarded by the group's mu
+	waiters int
+	dups    int
+}
+
+// Group coalesces concurrent calls for the same key into one execution whose
+// result and error are shared by every caller. The zero value is ready to use.
+type Group[K comparable, V any] struct {
+	mu      sync.Mutex
+	flights map[K]*flight[V]
+}
+
+// Do runs fn for key unless a call for key is already in flight, in which case
+// it waits for that one instead. shared reports whether the result went to
+// more than one caller.
+//
+// fn doesn't get any one caller's ctx: a caller that gives up only stops its
+// own wait, and fn is cancelled once every caller waiting on it has given up.
+// A panic in fn is returned to all of them as a *PanicError.
+func (g *Group[K, V]) Do(ctx context.Context, key K, fn func(context.Context) (V, error)) (v V, err error, shared bool) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[K]*flight[V])
+	}
+
+	if f, ok := g.flights[key]; ok {
+		f.waiters++
+		f.dups++
+		g.mu.Unlock()
+		return g.wait(ctx, key, f)
+	}
+
+	fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f := &flight[V]{
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		waiters: 1,
+	}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	go func() {
+		defer cancel()
+
+		err := runRecovered(fctx, func(ctx context.Context) error {
+			var err error
+			f.v, err = fn(ctx)
+			return err
+		})
+		f.err = err
+
+		g.mu.Lock()
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		g.mu.Unlock()
+
+		close(f.done)
+	}()
+
+	return g.wait(ctx, key, f)
+}
+
+func (g *Group[K, V]) wait(ctx context.Context, key K, f *flight[V]) (V, error, bool) {
+	select {
+	case <-f.done:
+		g.mu.Lock()
+		shared := f.dups > 0
+		g.mu.Unlock()
+		return f.v, f.err, shared
+
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		shared := f.dups > 0
+		if f.waiters == 0 {
+			// nobody's left to hand the result to
+			f.cancel()
+			if g.flights[key] == f {
+				delete(g.flights, key)
+			}
+		}
+		g.mu.Unlock()
+
+		var zero V
+		return zero, ctx.Err(), shared
+	}
+}
+
+// Forget makes the next Do for key start a new execution rather than join the
+// one in flight. Callers already waiting still get its result.
+func (g *Group[K, V]) Forget(key K) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.flights, key)
+}
@@ -0,0 +1,136 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+// waitForWaiters blocks until n callers are waiting on key's flight.
+func waitForWaiters[K comparable, V any](t *testing.T, g *Group[K, V], key K, n int) {
+	t.Helper()
+	waitFor(t, "callers to join the flight", func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		f, ok := g.flights[key]
+		return ok && f.waiters == n
+	})
+}
+
+func TestGroupCoalesces(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	var g Group[string, int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for range callers {
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do(context.Background(), "k", fn)
+			if v != 42 || err != nil || !shared {
+				t.Errorf("Do = %d, %v, shared %v; want 42, nil, shared", v, err, shared)
+			}
+		}()
+	}
+
+	waitForWaiters(t, &g, "k", callers)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("fn ran %d times, want 1", n)
+	}
+
+	// the flight is gone once it's landed, so the next call runs fn again
+	v, _, shared := g.Do(context.Background(), "k", func(context.Context) (int, error) { return 7, nil })
+	if v != 7 || shared {
+		t.Fatalf("later Do = %d, shared %v; want a fresh 7", v, shared)
+	}
+}
+
+func TestGroupForget(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	var g Group[string, int]
+	release := make(chan struct{})
+
+	first := make(chan int)
+	go func() {
+		v, _, _ := g.Do(context.Background(), "k", func(context.Context) (int, error) {
+			<-release
+			return 1, nil
+		})
+		first <- v
+	}()
+	waitForWaiters(t, &g, "k", 1)
+
+	g.Forget("k")
+	v, _, shared := g.Do(context.Background(), "k", func(context.Context) (int, error) { return 2, nil })
+	if v != 2 || shared {
+		t.Fatalf("Do after Forget = %d, shared %v; want its own 2", v, shared)
+	}
+
+	close(release)
+	if v := <-first; v != 1 {
+		t.Fatalf("forgotten flight returned %d to its caller, want 1", v)
+	}
+}
+
+func TestGroupCancel(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	var g Group[string, int]
+	fnCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (int, error) {
+		fnCtx <- ctx
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, err, _ := g.Do(ctx1, "k", fn); errs <- err }()
+	fctx := <-fnCtx
+	go func() { _, err, _ := g.Do(ctx2, "k", fn); errs <- err }()
+	waitForWaiters(t, &g, "k", 2)
+
+	// one caller leaving only ends its own wait
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller got %v", err)
+	}
+	if fctx.Err() != nil {
+		t.Fatal("fn cancelled while a caller was still waiting")
+	}
+
+	// the last one leaving cancels fn
+	cancel2()
+	<-errs
+	waitFor(t, "fn to be cancelled", func() bool { return fctx.Err() != nil })
+}
+
+func TestGroupPanic(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	var g Group[string, int]
+	_, err, _ := g.Do(context.Background(), "k", func(context.Context) (int, error) { panic("boom") })
+
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Fatalf("err = %v, want a *PanicError", err)
+	}
+}
@@ -0,0 +1,363 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSupervisorStarted = errors.New("supervisor already started")
+	ErrDuplicateWorker   = errors.New("worker name already in use")
+)
+
+// PanicError is a recovered panic turned into an error.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
+// RestartStrategy decides who gets restarted when a worker fails.
+type RestartStrategy int
+
+const (
+	// OneForOne restarts only the worker that failed.
+	OneForOne RestartStrategy = iota
+	// OneForAll stops and restarts every worker when any one of them fails.
+	OneForAll
+)
+
+// WorkerState is where a supervised worker is in its lifecycle.
+type WorkerState int
+
+const (
+	WorkerRunning WorkerState = iota
+	WorkerBackingOff
+	WorkerStopped // returned nil or the supervisor was stopped
+	WorkerFailed  // gave up after MaxRestarts
+)
+
+func (s WorkerState) String() string {
+	switch s {
+	case WorkerRunning:
+		return "running"
+	case WorkerBackingOff:
+		return "backing off"
+	case WorkerStopped:
+		return "stopped"
+	case WorkerFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("WorkerState(%d)", int(s))
+	}
+}
+
+// WorkerStatus is a snapshot of one supervised worker.
+type WorkerStatus struct {
+	Name     string
+	State    WorkerState
+	Restarts int
+	LastErr  error
+}
+
+// SupervisorConfig configures a Supervisor. Zero values get sane defaults.
+type SupervisorConfig struct {
+	Strategy RestartStrategy
+
+	// backoff doubles from MinBackoff up to MaxBackoff between restarts, and
+	// starts over once a run has stayed up for longer than MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// consecutive failures before a worker is given up on, 0 means never
+	MaxRestarts int
+
+	// OnError, if set, is called with every error or recovered panic
+	OnError func(name string, err error)
+
+	// nil means the real one
+	Clock Clock
+}
+
+type supervised struct {
+	name     string
+	run      func(context.Context) error
+	state    WorkerState
+	restarts int
+	lastErr  error
+}
+
+// Supervisor owns a set of long running workers, recovers their panics and
+// restarts them with exponential backoff.
+type Supervisor struct {
+	cfg SupervisorConfig
+
+	mu      sync.Mutex
+	workers []*supervised
+	started bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewSupervisor creates a supervisor; add workers to it before Start.
+func NewSupervisor(cfg SupervisorConfig) *Supervisor {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(30*time.Second, cfg.MinBackoff)
+	}
+	cfg.Clock = orReal(cfg.Clock)
+	return &Supervisor{cfg: cfg}
+}
+
+// Add registers a worker. run should block until ctx is done; returning an
+// error or panicking counts as a failure, returning nil as a clean stop.
+func (s *Supervisor) Add(name string, run func(ctx context.Context) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return ErrSupervisorStarted
+	}
+	for _, w := range s.workers {
+		if w.name == name {
+			return fmt.Errorf("%w: %s", ErrDuplicateWorker, name)
+		}
+	}
+
+	s.workers = append(s.workers, &supervised{name: name, run: run})
+	return nil
+}
+
+// Start launches every worker. They run until Stop is called or ctx is done.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return ErrSupervisorStarted
+	}
+	s.started = true
+
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	switch s.cfg.Strategy {
+	case OneForAll:
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.superviseAll(ctx, s.workers)
+		}()
+	default:
+		s.wg.Add(len(s.workers))
+		for _, w := range s.workers {
+			go func() {
+				defer s.wg.Done()
+				s.superviseOne(ctx, w)
+			}()
+		}
+	}
+
+	return nil
+}
+
+// Stop cancels every worker and waits for them to return.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	s.wg.Wait()
+}
+
+// Statuses returns the state of every worker in the order they were added.
+func (s *Supervisor) Statuses() []WorkerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]WorkerStatus, 0, len(s.workers))
+	for _, w := range s.workers {
+		out = append(out, WorkerStatus{
+			Name:     w.name,
+			State:    w.state,
+			Restarts: w.restarts,
+			LastErr:  w.lastErr,
+		})
+	}
+	return out
+}
+
+// State returns the state of the named worker.
+func (s *Supervisor) State(name string) (WorkerState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range s.workers {
+		if w.name == name {
+			return w.state, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Supervisor) superviseOne(ctx context.Context, w *supervised) {
+	failures := 0
+	for {
+		s.setState(w, WorkerRunning)
+		started := s.cfg.Clock.Now()
+		err := runRecovered(ctx, w.run)
+
+		if err == nil || ctx.Err() != nil {
+			s.setState(w, WorkerStopped)
+			return
+		}
+		s.report(w, err)
+
+		if s.cfg.Clock.Since(started) > s.cfg.MaxBackoff {
+			failures = 0
+		}
+		failures++
+		if s.cfg.MaxRestarts > 0 && failures > s.cfg.MaxRestarts {
+			s.setState(w, WorkerFailed)
+			return
+		}
+
+		s.setState(w, WorkerBackingOff)
+		if !sleepCtx(ctx, s.cfg.Clock, s.backoff(failures)) {
+			s.setState(w, WorkerStopped)
+			return
+		}
+		s.restarted(w)
+	}
+}
+
+func (s *Supervisor) superviseAll(ctx context.Context, workers []*supervised) {
+	failures := 0
+	live := workers
+	for {
+		gctx, cancel := context.WithCancel(ctx)
+		failed := make(chan struct{}, len(live))
+		started := s.cfg.Clock.Now()
+
+		var wg sync.WaitGroup
+		wg.Add(len(live))
+		for _, w := range live {
+			s.setState(w, WorkerRunning)
+			go func() {
+				defer wg.Done()
+				err := runRecovered(gctx, w.run)
+				switch {
+				case gctx.Err() != nil:
+					// taken down along with its siblings
+				case err == nil:
+					s.setState(w, WorkerStopped)
+				default:
+					s.report(w, err)
+					failed <- struct{}{}
+					cancel()
+				}
+			}()
+		}
+		wg.Wait()
+		cancel()
+
+		if ctx.Err() != nil || len(failed) == 0 {
+			s.setStates(live, WorkerStopped)
+			return
+		}
+
+		// the ones that finished cleanly before the failure stay stopped
+		var next []*supervised
+		for _, w := range live {
+			if s.getState(w) != WorkerStopped {
+				next = append(next, w)
+			}
+		}
+		live = next
+
+		if s.cfg.Clock.Since(started) > s.cfg.MaxBackoff {
+			failures = 0
+		}
+		failures++
+		if s.cfg.MaxRestarts > 0 && failures > s.cfg.MaxRestarts {
+			s.setStates(live, WorkerFailed)
+			return
+		}
+
+		s.setStates(live, WorkerBackingOff)
+		if !sleepCtx(ctx, s.cfg.Clock, s.backoff(failures)) {
+			s.setStates(live, WorkerStopped)
+			return
+		}
+		for _, w := range live {
+			s.restarted(w)
+		}
+	}
+}
+
+// backoff returns the delay before restart number n (starting at 1).
+func (s *Supervisor) backoff(n int) time.Duration {
+	d := s.cfg.MinBackoff
+	for i := 1; i < n && d < s.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, s.cfg.MaxBackoff)
+}
+
+func (s *Supervisor) report(w *supervised, err error) {
+	s.mu.Lock()
+	w.lastErr = err
+	s.mu.Unlock()
+
+	if s.cfg.OnError != nil {
+		s.cfg.OnError(w.name, err)
+	}
+}
+
+func (s *Supervisor) restarted(w *supervised) {
+	s.mu.Lock()
+	w.restarts++
+	s.mu.Unlock()
+}
+
+func (s *Supervisor) getState(w *supervised) WorkerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return w.state
+}
+
+func (s *Supervisor) setState(w *supervised, state WorkerState) {
+	s.mu.Lock()
+	w.state = state
+	s.mu.Unlock()
+}
+
+func (s *Supervisor) setStates(ws []*supervised, state WorkerState) {
+	s.mu.Lock()
+	for _, w := range ws {
+		w.state = state
+	}
+	s.mu.Unlock()
+}
+
+// runRecovered calls fn, turning a panic into a *PanicError.
+func runRecovered(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,215 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+// waitFor polls cond for up to a second of real time; the supervisor's own
+// goroutines need a moment to act on what the fake clock did.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func stateIs(s *Supervisor, name string, want WorkerState) func() bool {
+	return func() bool {
+		st, _ := s.State(name)
+		return st == want
+	}
+}
+
+// failOnCue is a worker that runs until told to fail, or ctx is done.
+func failOnCue(fail <-chan error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		select {
+		case err := <-fail:
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func TestSupervisorGivesUpOnPanics(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	var panics atomic.Int32
+	s := NewSupervisor(SupervisorConfig{
+		MinBackoff:  time.Second,
+		MaxBackoff:  time.Minute,
+		MaxRestarts: 2,
+		Clock:       fc,
+		OnError: func(_ string, err error) {
+			var pe *PanicError
+			if errors.As(err, &pe) {
+				panics.Add(1)
+			}
+		},
+	})
+	s.Add("boom", func(context.Context) error { panic("boom") })
+	s.Start(context.Background())
+	defer s.Stop()
+
+	for range 2 {
+		fc.BlockUntil(1)
+		fc.Advance(time.Minute)
+	}
+
+	waitFor(t, "the worker to fail for good", stateIs(s, "boom", WorkerFailed))
+	st := s.Statuses()[0]
+	if st.Restarts != 2 || panics.Load() != 3 {
+		t.Fatalf("restarts = %d, panics = %d; want 2 and 3", st.Restarts, panics.Load())
+	}
+	var pe *PanicError
+	if !errors.As(st.LastErr, &pe) || pe.Value != "boom" {
+		t.Fatalf("LastErr = %v, want the recovered panic", st.LastErr)
+	}
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	fail := make(chan error)
+	s := NewSupervisor(SupervisorConfig{
+		MinBackoff: time.Second,
+		MaxBackoff: 4 * time.Second,
+		Clock:      fc,
+	})
+	s.Add("w", failOnCue(fail))
+	s.Start(context.Background())
+	defer s.Stop()
+
+	errDown := errors.New("down")
+	expectBackoff := func(d time.Duration, restarts int) {
+		t.Helper()
+		fail <- errDown
+		fc.BlockUntil(1)
+		fc.Advance(d - time.Millisecond)
+		if fc.Pending() != 1 {
+			t.Fatalf("restarted before the %v backoff was up", d)
+		}
+		fc.Advance(time.Millisecond)
+		waitFor(t, "the restart", func() bool {
+			st := s.Statuses()[0]
+			return st.State == WorkerRunning && st.Restarts == restarts
+		})
+	}
+
+	// doubles up to MaxBackoff
+	expectBackoff(time.Second, 1)
+	expectBackoff(2*time.Second, 2)
+	expectBackoff(4*time.Second, 3)
+	expectBackoff(4*time.Second, 4)
+
+	// and starts over after a run that stayed up longer than MaxBackoff
+	fc.Advance(5 * time.Second)
+	expectBackoff(time.Second, 5)
+}
+
+func TestSupervisorOneForAll(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	fail := make(chan error)
+	var siblingRuns atomic.Int32
+	s := NewSupervisor(SupervisorConfig{
+		Strategy:   OneForAll,
+		MinBackoff: time.Second,
+		Clock:      fc,
+	})
+	s.Add("flaky", failOnCue(fail))
+	s.Add("sibling", func(ctx context.Context) error {
+		siblingRuns.Add(1)
+		<-ctx.Done()
+		return nil
+	})
+	s.Start(context.Background())
+	defer s.Stop()
+
+	waitFor(t, "the sibling to start", func() bool { return siblingRuns.Load() == 1 })
+	fail <- errors.New("down")
+
+	fc.BlockUntil(1)
+	if st, _ := s.State("sibling"); st != WorkerBackingOff {
+		t.Fatalf("sibling is %v while the group backs off", st)
+	}
+	fc.Advance(time.Second)
+
+	waitFor(t, "the sibling to be restarted", func() bool { return siblingRuns.Load() == 2 })
+	for _, st := range s.Statuses() {
+		if st.Restarts != 1 {
+			t.Fatalf("%s restarted %d times, want 1", st.Name, st.Restarts)
+		}
+	}
+}
+
+func TestSupervisorOneForOneLeavesSiblings(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	fail := make(chan error)
+	var siblingRuns atomic.Int32
+	s := NewSupervisor(SupervisorConfig{MinBackoff: time.Second, Clock: fc})
+	s.Add("flaky", failOnCue(fail))
+	s.Add("sibling", func(ctx context.Context) error {
+		siblingRuns.Add(1)
+		<-ctx.Done()
+		return nil
+	})
+	s.Start(context.Background())
+	defer s.Stop()
+
+	fail <- errors.New("down")
+	fc.BlockUntil(1)
+	fc.Advance(time.Second)
+	waitFor(t, "the restart", stateIs(s, "flaky", WorkerRunning))
+
+	if n := siblingRuns.Load(); n != 1 {
+		t.Fatalf("sibling ran %d times, want 1", n)
+	}
+}
+
+func TestSupervisorStopDuringBackoff(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	fail := make(chan error)
+	s := NewSupervisor(SupervisorConfig{MinBackoff: time.Hour, Clock: fc})
+	s.Add("w", failOnCue(fail))
+	s.Start(context.Background())
+
+	fail <- errors.New("down")
+	fc.BlockUntil(1)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop waited out the backoff")
+	}
+
+	if st, _ := s.State("w"); st != WorkerStopped {
+		t.Fatalf("state = %v after Stop, want stopped", st)
+	}
+	if fc.Pending() != 0 {
+		t.Fatal("Stop left the backoff timer behind")
+	}
+}
@@ -0,0 +1,76 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// TaskGroup is a WaitGroup that keeps the errors: the first failing task
+// cancels its siblings' ctx, Wait returns every error joined, and a panic in a
+// task comes back as a *PanicError rather than taking the program down.
+type TaskGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg  sync.WaitGroup
+	sem chan struct{}
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// NewTaskGroup creates a group and the ctx handed to its tasks, which is
+// cancelled by the first error or once Wait returns.
+func NewTaskGroup(ctx context.Context) (*TaskGroup, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &TaskGroup{ctx: ctx, cancel: cancel}, ctx
+}
+
+// SetLimit caps how many tasks run at once, n < 0 meaning no cap. Go blocks
+// while the group is at its limit. It can't be changed while tasks are running.
+func (g *TaskGroup) SetLimit(n int) {
+	if len(g.sem) != 0 {
+		panic("taskgroup: SetLimit called while tasks are running")
+	}
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// Go runs fn in a new goroutine, waiting first if the group is at its limit.
+func (g *TaskGroup) Go(fn func(ctx context.Context) error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			if g.sem != nil {
+				<-g.sem
+			}
+			g.wg.Done()
+		}()
+
+		if err := runRecovered(g.ctx, fn); err != nil {
+			g.mu.Lock()
+			g.errs = append(g.errs, err)
+			g.mu.Unlock()
+			g.cancel()
+		}
+	}()
+}
+
+// Wait blocks until every task has returned and joins their errors, nil if
+// they all succeeded.
+func (g *TaskGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.errs...)
+}
@@ -0,0 +1,128 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+func TestTaskGroupCancelsAndJoins(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	g, ctx := NewTaskGroup(context.Background())
+	errA, errB := errors.New("a"), errors.New("b")
+
+	started := make(chan struct{})
+	var siblingCancelled atomic.Bool
+	g.Go(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		siblingCancelled.Store(true)
+		return nil
+	})
+	<-started
+
+	g.Go(func(context.Context) error { return errA })
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return errB
+	})
+
+	err := g.Wait()
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Fatalf("Wait = %v, want a and b joined", err)
+	}
+	if !siblingCancelled.Load() {
+		t.Fatal("the first error didn't cancel the siblings")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("group ctx still live after Wait")
+	}
+}
+
+func TestTaskGroupOK(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	g, ctx := NewTaskGroup(context.Background())
+	var ran atomic.Int32
+	for range 10 {
+		g.Go(func(ctx context.Context) error {
+			if ctx.Err() != nil {
+				t.Error("task ctx cancelled with no error in the group")
+			}
+			ran.Add(1)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Wait = %v", err)
+	}
+	if n := ran.Load(); n != 10 {
+		t.Fatalf("%d tasks ran, want 10", n)
+	}
+	// Wait cancels the ctx either way
+	if ctx.Err() == nil {
+		t.Fatal("group ctx still live after Wait")
+	}
+}
+
+func TestTaskGroupPanic(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	g, _ := NewTaskGroup(context.Background())
+	g.Go(func(context.Context) error { panic("boom") })
+
+	var pe *PanicError
+	if err := g.Wait(); !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Fatalf("Wait = %v, want a *PanicError", err)
+	}
+}
+
+func TestTaskGroupLimit(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	const limit = 3
+	g, _ := NewTaskGroup(context.Background())
+	g.SetLimit(limit)
+
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	launched := make(chan struct{})
+	go func() {
+		defer close(launched)
+		for range 10 {
+			g.Go(func(context.Context) error {
+				n := running.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				<-release
+				running.Add(-1)
+				return nil
+			})
+		}
+	}()
+
+	waitFor(t, "the group to fill up", func() bool { return running.Load() == limit })
+	select {
+	case <-launched:
+		t.Fatal("Go didn't block at the limit")
+	default:
+	}
+
+	close(release)
+	<-launched
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Wait = %v", err)
+	}
+	if p := peak.Load(); p > limit {
+		t.Fatalf("%d tasks ran at once, limit %d", p, limit)
+	}
+}
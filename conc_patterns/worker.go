@@ -0,0 +1,208 @@
+package concpatterns
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// OverlapPolicy decides what a worker does when a tick comes in while process
+// is still running from an earlier one.
+type OverlapPolicy int
+
+const (
+	// OverlapQueueOne remembers at most one missed tick and runs it as soon as
+	// the current call returns, which is what a plain ticker loop does.
+	OverlapQueueOne OverlapPolicy = iota
+	// OverlapSkip drops ticks that land mid-run.
+	OverlapSkip
+	// OverlapConcurrent starts another call, up to MaxConcurrent at once.
+	OverlapConcurrent
+)
+
+// WorkerOptions configures RunWorkerWithOptions and ProcessWorker. Zero fields
+// fall back to DefaultWorkerOptions.
+type WorkerOptions struct {
+	Interval        time.Duration
+	BatchLimit      int
+	ShutdownTimeout time.Duration
+
+	// each tick is delayed by a random extra [0, Jitter), so replicas started
+	// together don't all hit the same dependency at the same moment
+	Jitter time.Duration
+
+	// run once right away instead of waiting for the first tick
+	RunOnStart bool
+
+	Overlap       OverlapPolicy
+	MaxConcurrent int // OverlapConcurrent only
+
+	// nil means the real one
+	Clock Clock
+}
+
+// DefaultWorkerOptions are the values RunWorkerWithStop has always used.
+func DefaultWorkerOptions() WorkerOptions {
+	return WorkerOptions{
+		Interval:        time.Second * 5,
+		BatchLimit:      50,
+		ShutdownTimeout: time.Second * 15,
+		MaxConcurrent:   1,
+	}
+}
+
+func (o WorkerOptions) withDefaults() WorkerOptions {
+	d := DefaultWorkerOptions()
+	if o.Interval <= 0 {
+		o.Interval = d.Interval
+	}
+	if o.BatchLimit <= 0 {
+		o.BatchLimit = d.BatchLimit
+	}
+	if o.ShutdownTimeout <= 0 {
+		o.ShutdownTimeout = d.ShutdownTimeout
+	}
+	if o.MaxConcurrent <= 0 {
+		o.MaxConcurrent = d.MaxConcurrent
+	}
+	o.Clock = orReal(o.Clock)
+	return o
+}
+
+func (o WorkerOptions) nextTick() time.Duration {
+	if o.Jitter <= 0 {
+		return o.Interval
+	}
+	return o.Interval + time.Duration(rand.Int63n(int64(o.Jitter)))
+}
+
+// StopFunc stops a worker started by RunWorkerWithStop. It cancels the ctx
+// handed to process and waits for in-flight calls to return, for at most
+// ShutdownTimeout or until ctx is done. Calling it again is safe: once the
+// worker has stopped it returns nil straight away.
+type StopFunc func(ctx context.Context) error
+
+// StopTimeoutError is returned by a StopFunc that gave up waiting.
+type StopTimeoutError struct {
+	Timeout time.Duration
+	Err     error // the ctx error that ended the wait
+}
+
+func (e *StopTimeoutError) Error() string {
+	return fmt.Sprintf("check worker did not stop within %s: %v", e.Timeout, e.Err)
+}
+
+func (e *StopTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// RunWorkerWithOptions is RunWorkerWithStop with its schedule spelled out.
+func RunWorkerWithOptions(process func(context.Context, int, time.Time) error, opts WorkerOptions) StopFunc {
+	opts = opts.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		runWorker(ctx, process, opts, func(err error) {
+			fmt.Printf("Failed to run check: %v\n", err)
+		})
+	}()
+
+	return func(ctx context.Context) error {
+		cancel()
+
+		ctx, stop := withTimeout(ctx, opts.Clock, opts.ShutdownTimeout)
+		defer stop()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return &StopTimeoutError{Timeout: opts.ShutdownTimeout, Err: context.Cause(ctx)}
+		}
+	}
+}
+
+// ProcessWorker is RunWorkerWithOptions shaped for a Supervisor: it runs until
+// ctx is done, and a process error ends the run instead of only being logged,
+// so the supervisor can restart it.
+func ProcessWorker(process func(context.Context, int, time.Time) error, opts WorkerOptions) func(context.Context) error {
+	opts = opts.withDefaults()
+
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var once sync.Once
+		var runErr error
+		runWorker(ctx, process, opts, func(err error) {
+			once.Do(func() {
+				runErr = fmt.Errorf("run check: %w", err)
+				cancel()
+			})
+		})
+
+		return runErr
+	}
+}
+
+// runWorker calls process on opts' schedule until ctx is done, then waits for
+// the calls still in flight. process gets ctx so long runs can be interrupted.
+// Errors and panics from process go to onErr.
+func runWorker(ctx context.Context, process func(context.Context, int, time.Time) error, opts WorkerOptions, onErr func(error)) {
+	finished := make(chan struct{})
+	running := 0
+	pending := false
+
+	start := func(now time.Time) {
+		running++
+		go func() {
+			defer func() { finished <- struct{}{} }()
+			err := runRecovered(ctx, func(ctx context.Context) error {
+				return process(ctx, opts.BatchLimit, now)
+			})
+			if err != nil {
+				onErr(err)
+			}
+		}()
+	}
+
+	timer := opts.Clock.NewTimer(opts.nextTick())
+	defer timer.Stop()
+
+	if opts.RunOnStart {
+		start(opts.Clock.Now())
+	}
+
+	for {
+		select {
+		case now := <-timer.C():
+			timer.Reset(opts.nextTick())
+			switch {
+			case running == 0:
+				start(now)
+			case opts.Overlap == OverlapQueueOne:
+				pending = true
+			case opts.Overlap == OverlapConcurrent && running < opts.MaxConcurrent:
+				start(now)
+			}
+
+		case <-finished:
+			running--
+			if pending && running == 0 {
+				pending = false
+				start(opts.Clock.Now())
+			}
+
+		case <-ctx.Done():
+			for ; running > 0; running-- {
+				<-finished
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package concpatterns
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ardan/conc_patterns/leakcheck"
+)
+
+// testProcess is a process func for the worker that reports every call and
+// then holds it until it's released or its ctx is done.
+type testProcess struct {
+	started chan time.Time
+	release chan struct{}
+	batch   atomic.Int64
+
+	running, peak atomic.Int32
+}
+
+func newTestProcess() *testProcess {
+	return &testProcess{
+		started: make(chan time.Time, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (p *testProcess) process(ctx context.Context, n int, now time.Time) error {
+	r := p.running.Add(1)
+	defer p.running.Add(-1)
+	for {
+		if old := p.peak.Load(); r <= old || p.peak.CompareAndSwap(old, r) {
+			break
+		}
+	}
+	p.batch.Store(int64(n))
+	p.started <- now
+
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// next returns the time the next call was started for.
+func (p *testProcess) next(t *testing.T) time.Time {
+	t.Helper()
+	select {
+	case now := <-p.started:
+		return now
+	case <-time.After(time.Second):
+		t.Fatal("process wasn't called")
+		return time.Time{}
+	}
+}
+
+// none checks no call starts in the next little while.
+func (p *testProcess) none(t *testing.T) {
+	t.Helper()
+	select {
+	case <-p.started:
+		t.Fatal("process called when it shouldn't have been")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// tick waits for the worker to set its timer and fires it.
+func tick(fc *FakeClock, interval time.Duration) {
+	fc.BlockUntil(1)
+	fc.Advance(interval)
+}
+
+func stopWorker(t *testing.T, stop StopFunc) {
+	t.Helper()
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop = %v", err)
+	}
+}
+
+func TestWorkerSchedule(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	p := newTestProcess()
+	stop := RunWorkerWithOptions(p.process, WorkerOptions{
+		Interval:   5 * time.Second,
+		BatchLimit: 7,
+		Clock:      fc,
+	})
+	defer stopWorker(t, stop)
+
+	for i := 1; i <= 3; i++ {
+		fc.BlockUntil(1)
+		fc.Advance(5*time.Second - time.Nanosecond)
+		if fc.Pending() != 1 {
+			t.Fatalf("tick %d came early", i)
+		}
+		fc.Advance(time.Nanosecond)
+
+		if now, want := p.next(t), epoch.Add(time.Duration(i)*5*time.Second); !now.Equal(want) {
+			t.Fatalf("tick %d called process for %v, want %v", i, now, want)
+		}
+		if n := p.batch.Load(); n != 7 {
+			t.Fatalf("process got batch limit %d, want 7", n)
+		}
+		p.release <- struct{}{}
+	}
+}
+
+func TestWorkerRunOnStart(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	p := newTestProcess()
+	stop := RunWorkerWithOptions(p.process, WorkerOptions{
+		Interval:   5 * time.Second,
+		RunOnStart: true,
+		Clock:      fc,
+	})
+	defer stopWorker(t, stop)
+
+	if now := p.next(t); !now.Equal(epoch) {
+		t.Fatalf("first call for %v, want the start time", now)
+	}
+	p.release <- struct{}{}
+
+	// and the schedule carries on from there
+	tick(fc, 5*time.Second)
+	p.next(t)
+	p.release <- struct{}{}
+}
+
+func TestWorkerJitter(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	const interval, jitter = 5 * time.Second, 2 * time.Second
+
+	fc := NewFakeClock(epoch)
+	p := newTestProcess()
+	stop := RunWorkerWithOptions(p.process, WorkerOptions{
+		Interval: interval,
+		Jitter:   jitter,
+		Clock:    fc,
+	})
+	defer stopWorker(t, stop)
+
+	delays := make(map[time.Duration]bool)
+	for range 20 {
+		fc.BlockUntil(1)
+		set := fc.Now()
+
+		// never before Interval
+		fc.Advance(interval - time.Nanosecond)
+		if fc.Pending() != 1 {
+			t.Fatal("tick came before Interval")
+		}
+		// and always within Jitter of it
+		fc.Advance(jitter)
+
+		d := p.next(t).Sub(set)
+		if d < interval || d >= interval+jitter {
+			t.Fatalf("tick after %v, want [%v, %v)", d, interval, interval+jitter)
+		}
+		delays[d] = true
+		p.release <- struct{}{}
+	}
+
+	if len(delays) < 2 {
+		t.Fatalf("every tick came after the same %v", delays)
+	}
+}
+
+func TestWorkerOverlap(t *testing.T) {
+	const interval = time.Second
+
+	t.Run("skip", func(t *testing.T) {
+		defer leakcheck.Check(t)()
+
+		fc := NewFakeClock(epoch)
+		p := newTestProcess()
+		stop := RunWorkerWithOptions(p.process, WorkerOptions{
+			Interval: interval,
+			Overlap:  OverlapSkip,
+			Clock:    fc,
+		})
+		defer stopWorker(t, stop)
+
+		tick(fc, interval)
+		p.next(t)
+		for range 3 {
+			tick(fc, interval)
+		}
+		p.release <- struct{}{}
+
+		// the ticks that landed mid-run are gone
+		p.none(t)
+		tick(fc, interval)
+		p.next(t)
+		p.release <- struct{}{}
+	})
+
+	t.Run("queue one", func(t *testing.T) {
+		defer leakcheck.Check(t)()
+
+		fc := NewFakeClock(epoch)
+		p := newTestProcess()
+		stop := RunWorkerWithOptions(p.process, WorkerOptions{
+			Interval: interval,
+			Overlap:  OverlapQueueOne,
+			Clock:    fc,
+		})
+		defer stopWorker(t, stop)
+
+		tick(fc, interval)
+		p.next(t)
+		for range 3 {
+			tick(fc, interval)
+		}
+		p.release <- struct{}{}
+
+		// one of the missed ticks runs straight away, the rest are forgotten
+		p.next(t)
+		p.release <- struct{}{}
+		p.none(t)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		defer leakcheck.Check(t)()
+
+		fc := NewFakeClock(epoch)
+		p := newTestProcess()
+		stop := RunWorkerWithOptions(p.process, WorkerOptions{
+			Interval:      interval,
+			Overlap:       OverlapConcurrent,
+			MaxConcurrent: 2,
+			Clock:         fc,
+		})
+		defer stopWorker(t, stop)
+
+		tick(fc, interval)
+		p.next(t)
+		tick(fc, interval)
+		p.next(t)
+
+		// a third would be over MaxConcurrent
+		tick(fc, interval)
+		p.none(t)
+		if n := p.peak.Load(); n != 2 {
+			t.Fatalf("%d calls at once, want 2", n)
+		}
+
+		// with one done there's room again
+		p.release <- struct{}{}
+		p.none(t)
+		tick(fc, interval)
+		p.next(t)
+		p.release <- struct{}{}
+		p.release <- struct{}{}
+	})
+}
+
+func TestProcessWorkerUnderSupervisor(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	errCheck := errors.New("check failed")
+	var calls atomic.Int32
+	process := func(context.Context, int, time.Time) error {
+		if calls.Add(1) == 1 {
+			return errCheck
+		}
+		return nil
+	}
+
+	s := NewSupervisor(SupervisorConfig{MinBackoff: time.Second, Clock: fc})
+	s.Add("check", ProcessWorker(process, WorkerOptions{
+		Interval:   5 * time.Second,
+		RunOnStart: true,
+		Clock:      fc,
+	}))
+	s.Start(context.Background())
+
+	// the failed call ends the run, and the supervisor restarts it
+	waitFor(t, "the worker to back off", stateIs(s, "check", WorkerBackingOff))
+	if st := s.Statuses()[0]; !errors.Is(st.LastErr, errCheck) {
+		t.Fatalf("LastErr = %v, want the process error", st.LastErr)
+	}
+	fc.BlockUntil(1)
+	fc.Advance(time.Second)
+	waitFor(t, "the restart to run process", func() bool { return calls.Load() == 2 })
+
+	// a good call keeps it running on schedule
+	tick(fc, 5*time.Second)
+	waitFor(t, "the next tick", func() bool { return calls.Load() == 3 })
+	if st := s.Statuses()[0]; st.State != WorkerRunning || st.Restarts != 1 {
+		t.Fatalf("status = %+v, want running after 1 restart", st)
+	}
+
+	s.Stop()
+	if st, _ := s.State("check"); st != WorkerStopped {
+		t.Fatalf("state = %v after Stop, want stopped", st)
+	}
+}
+
+func TestWorkerStop(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	p := newTestProcess()
+	stop := RunWorkerWithOptions(p.process, WorkerOptions{RunOnStart: true, Clock: fc})
+	p.next(t)
+
+	// the call in flight is let go through its ctx
+	stopWorker(t, stop)
+	if n := p.running.Load(); n != 0 {
+		t.Fatalf("%d calls still running after stop", n)
+	}
+
+	// and stopping again is a no-op
+	done := make(chan error)
+	go func() { done <- stop(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("second stop = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second stop blocked")
+	}
+}
+
+func TestWorkerStopTimeout(t *testing.T) {
+	defer leakcheck.Check(t)()
+
+	fc := NewFakeClock(epoch)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	// doesn't listen to its ctx
+	process := func(context.Context, int, time.Time) error {
+		close(started)
+		<-release
+		return nil
+	}
+	stop := RunWorkerWithOptions(process, WorkerOptions{
+		RunOnStart:      true,
+		ShutdownTimeout: 10 * time.Second,
+		Clock:           fc,
+	})
+	<-started
+
+	errc := make(chan error)
+	go func() { errc <- stop(context.Background()) }()
+	// the worker's own timer and the shutdown timeout
+	fc.BlockUntil(2)
+	fc.Advance(10 * time.Second)
+
+	err := <-errc
+	var ste *StopTimeoutError
+	if !errors.As(err, &ste) || ste.Timeout != 10*time.Second || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stop = %v, want a *StopTimeoutError after 10s", err)
+	}
+
+	// the caller's ctx ends the wait too
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := stop(ctx); !errors.As(err, &ste) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("stop with a cancelled ctx = %v, want a *StopTimeoutError", err)
+	}
+
+	// once the call returns a later stop sees the worker done
+	close(release)
+	stopWorker(t, stop)
+}
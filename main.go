@@ -7,6 +7,7 @@ import (
 
 func main() {
 	// conc.SyncWithMutex()
+	// conc.SyncWithCounter()
 	// conc.WaitForResult()
 	// conc.FanOut()
 	// conc.Pooling()
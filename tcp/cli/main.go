@@ -2,14 +2,31 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
+
+	"ardan/tcp/frame"
+)
+
+var (
+	maxFrame = flag.Int("max-frame", frame.DefaultMaxSize, "largest response accepted, in bytes")
+	framing  = frame.LengthPrefixed
 )
 
+func init() {
+	flag.Var(&framing, "framing", "message framing, length or line (must match the server)")
+}
+
 // from proj root -- go run tcp/cli/main.go
 func main() {
+	flag.Parse()
+
 	conn, err := net.Dial("tcp", "127.0.0.1:8080")
 	if err != nil {
 		log.Fatalf("Error connecting: %s\n", err)
@@ -17,27 +34,35 @@ func main() {
 	defer conn.Close()
 
 	reader := bufio.NewReader(os.Stdin)
+	fr := frame.NewReader(conn, framing, *maxFrame)
+	fw := frame.NewWriter(conn, framing, *maxFrame)
 
 	for {
 
 		fmt.Print("Enter message to send to server: ")
 		msg, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			return
+		}
 		if err != nil {
 			log.Fatalf("Error reading input: %v", err)
 		}
 
-		msg = msg[:len(msg)-1]
+		msg = strings.TrimRight(msg, "\r\n")
 
-		if _, err = conn.Write([]byte(msg)); err != nil {
+		if err = fw.WriteFrame([]byte(msg)); err != nil {
 			log.Fatalf("Error sending request: %s\n", err)
 		}
 
-		buf := make([]byte, 1024)
-		n, err := conn.Read(buf)
+		resp, err := fr.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			// idle for too long or out of requests
+			fmt.Println("Server closed the connection")
+			return
+		}
 		if err != nil {
 			log.Fatalf("Error reading response: %s\n", err)
 		}
-		// include only the n read bytes in resp printout
-		fmt.Printf("Server response: %s\n", string(buf[:n]))
+		fmt.Printf("Server response: %s\n", resp)
 	}
 }
@@ -0,0 +1,168 @@
+// Package frame delimits messages on a stream connection, so a message is read
+// back whole however TCP splits or coalesces the writes.
+package frame
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxSize is the largest frame accepted unless told otherwise.
+const DefaultMaxSize = 64 << 10
+
+var (
+	ErrTooLarge          = errors.New("frame: exceeds max size")
+	ErrNewlineInPayload  = errors.New("frame: payload contains a newline")
+	errUnknownModeString = errors.New("frame: unknown mode, want length or line")
+)
+
+// Mode is how frames are delimited.
+type Mode int
+
+const (
+	// LengthPrefixed puts a 4 byte big-endian length in front of each frame.
+	LengthPrefixed Mode = iota
+	// Newline ends each frame with '\n'; frames can't contain one.
+	Newline
+)
+
+func (m Mode) String() string {
+	switch m {
+	case LengthPrefixed:
+		return "length"
+	case Newline:
+		return "line"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+// Set parses "length" or "line", which makes *Mode a flag.Value.
+func (m *Mode) Set(s string) error {
+	switch s {
+	case "length":
+		*m = LengthPrefixed
+	case "line":
+		*m = Newline
+	default:
+		return errUnknownModeString
+	}
+	return nil
+}
+
+// Reader reads frames off a stream.
+type Reader struct {
+	r       *bufio.Reader
+	mode    Mode
+	maxSize int
+}
+
+// NewReader creates a reader refusing frames over maxSize bytes, DefaultMaxSize
+// if maxSize isn't positive.
+func NewReader(r io.Reader, mode Mode, maxSize int) *Reader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &Reader{r: bufio.NewReader(r), mode: mode, maxSize: maxSize}
+}
+
+// ReadFrame returns the next frame. It returns io.EOF only when the stream
+// ends cleanly between frames, io.ErrUnexpectedEOF when it ends inside one.
+// After ErrTooLarge the stream can't be resynced and should be closed.
+func (r *Reader) ReadFrame() ([]byte, error) {
+	if r.mode == Newline {
+		return r.readLine()
+	}
+	return r.readLengthPrefixed()
+}
+
+func (r *Reader) readLengthPrefixed() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if uint64(n) > uint64(r.maxSize) {
+		return nil, fmt.Errorf("%w: %d > %d bytes", ErrTooLarge, n, r.maxSize)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (r *Reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		// the +1 leaves room for the delimiter itself
+		if len(line)+len(chunk) > r.maxSize+1 {
+			return nil, fmt.Errorf("%w: over %d bytes", ErrTooLarge, r.maxSize)
+		}
+		line = append(line, chunk...)
+
+		switch {
+		case err == nil:
+			line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+			return line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(line) > 0:
+			return nil, io.ErrUnexpectedEOF
+		default:
+			return nil, err
+		}
+	}
+}
+
+// Writer writes frames to a stream.
+type Writer struct {
+	w       io.Writer
+	mode    Mode
+	maxSize int
+}
+
+// NewWriter creates a writer refusing frames over maxSize bytes, DefaultMaxSize
+// if maxSize isn't positive.
+func NewWriter(w io.Writer, mode Mode, maxSize int) *Writer {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &Writer{w: w, mode: mode, maxSize: maxSize}
+}
+
+// WriteFrame writes p as one frame, in a single Write so concurrent writers
+// on the same conn can't interleave inside it.
+func (w *Writer) WriteFrame(p []byte) error {
+	if len(p) > w.maxSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrTooLarge, len(p), w.maxSize)
+	}
+
+	var buf []byte
+	switch w.mode {
+	case Newline:
+		if bytes.IndexByte(p, '\n') >= 0 {
+			return ErrNewlineInPayload
+		}
+		buf = make([]byte, 0, len(p)+1)
+		buf = append(buf, p...)
+		buf = append(buf, '\n')
+	default:
+		buf = make([]byte, 4, 4+len(p))
+		binary.BigEndian.PutUint32(buf, uint32(len(p)))
+		buf = append(buf, p...)
+	}
+
+	_, err := w.w.Write(buf)
+	return err
+}
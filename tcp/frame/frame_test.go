@@ -0,0 +1,290 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// lp length-prefixes each of frames.
+func lp(frames ...string) []byte {
+	var b []byte
+	for _, f := range frames {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+// bytewise splits b into 1 byte writes.
+func bytewise(b []byte) [][]byte {
+	writes := make([][]byte, len(b))
+	for i := range b {
+		writes[i] = b[i : i+1]
+	}
+	return writes
+}
+
+// feed returns a reader that gets writes one Write at a time through a pipe,
+// so a read never sees more than one write's worth.
+func feed(t *testing.T, writes [][]byte) io.Reader {
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pr.Close() })
+	go func() {
+		for _, w := range writes {
+			if _, err := pw.Write(w); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+func TestReadFrame(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	// the middle write ends one frame and starts the next
+	both := lp("abc", "de")
+
+	tests := []struct {
+		name    string
+		mode    Mode
+		maxSize int
+		writes  [][]byte
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "length/empty stream",
+			writes:  nil,
+			wantErr: io.EOF,
+		},
+		{
+			name:    "length/1 byte writes",
+			writes:  bytewise(lp("hello", "world")),
+			want:    []string{"hello", "world"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "length/coalesced",
+			writes:  [][]byte{lp("a", "", "bc")},
+			want:    []string{"a", "", "bc"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "length/split across frames",
+			writes:  [][]byte{both[:2], both[2:9], both[9:]},
+			want:    []string{"abc", "de"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "length/header cut short",
+			writes:  [][]byte{lp("ok"), {0, 0}},
+			want:    []string{"ok"},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "length/payload cut short",
+			writes:  [][]byte{lp("hello")[:6]},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "length/at max size",
+			maxSize: 3,
+			writes:  [][]byte{lp("abc")},
+			want:    []string{"abc"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "length/over max size",
+			maxSize: 3,
+			writes:  [][]byte{lp("abcd")},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "line/empty stream",
+			mode:    Newline,
+			wantErr: io.EOF,
+		},
+		{
+			name:    "line/1 byte writes",
+			mode:    Newline,
+			writes:  bytewise([]byte("hello\nworld\n")),
+			want:    []string{"hello", "world"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "line/coalesced CRLF and LF",
+			mode:    Newline,
+			writes:  [][]byte{[]byte("a\r\n\nb\n")},
+			want:    []string{"a", "", "b"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "line/only the last CR is trimmed",
+			mode:    Newline,
+			writes:  [][]byte{[]byte("a\r\r\n")},
+			want:    []string{"a\r"},
+			wantErr: io.EOF,
+		},
+		{
+			// longer than the bufio buffer, so ReadSlice hits ErrBufferFull
+			name:    "line/longer than the read buffer",
+			mode:    Newline,
+			writes:  [][]byte{[]byte(long + "\r\n"), []byte("next\n")},
+			want:    []string{long, "next"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "line/at max size",
+			mode:    Newline,
+			maxSize: 3,
+			writes:  [][]byte{[]byte("abc\n")},
+			want:    []string{"abc"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "line/over max size",
+			mode:    Newline,
+			maxSize: 3,
+			writes:  [][]byte{[]byte("abcd\n")},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "line/over max size across buffer fills",
+			mode:    Newline,
+			maxSize: 5000,
+			writes:  [][]byte{[]byte(long + "\n")},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "line/no trailing newline",
+			mode:    Newline,
+			writes:  [][]byte{[]byte("done\nhalf")},
+			want:    []string{"done"},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(feed(t, tt.writes), tt.mode, tt.maxSize)
+
+			var got []string
+			var err error
+			for {
+				var f []byte
+				if f, err = r.ReadFrame(); err != nil {
+					break
+				}
+				got = append(got, string(f))
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("frames = %q, want %q", got, tt.want)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadFrameTooLargeDoesNotAllocate(t *testing.T) {
+	// a header claiming 4GB-1 and nothing behind it
+	r := NewReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}), LengthPrefixed, 0)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := r.ReadFrame()
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("err = %v, want ErrTooLarge", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > DefaultMaxSize {
+		t.Fatalf("allocated %d bytes for a refused frame", n)
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	for _, mode := range []Mode{LengthPrefixed, Newline} {
+		t.Run(mode.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, mode, 8)
+
+			frames := []string{"hello", "", "8 bytes!"}
+			for _, f := range frames {
+				if err := w.WriteFrame([]byte(f)); err != nil {
+					t.Fatalf("WriteFrame(%q) = %v", f, err)
+				}
+			}
+			if err := w.WriteFrame([]byte("9 bytes!!")); !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("oversize WriteFrame = %v, want ErrTooLarge", err)
+			}
+
+			r := NewReader(&buf, mode, 8)
+			for _, want := range frames {
+				got, err := r.ReadFrame()
+				if err != nil || string(got) != want {
+					t.Fatalf("ReadFrame = %q, %v; want %q", got, err, want)
+				}
+			}
+			if _, err := r.ReadFrame(); !errors.Is(err, io.EOF) {
+				t.Fatalf("ReadFrame at the end = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+// countingWriter counts Write calls.
+type countingWriter struct {
+	io.Writer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Writer.Write(p)
+}
+
+func TestWriteFrameSingleWrite(t *testing.T) {
+	for _, mode := range []Mode{LengthPrefixed, Newline} {
+		cw := &countingWriter{Writer: io.Discard}
+		if err := NewWriter(cw, mode, 0).WriteFrame([]byte("payload")); err != nil {
+			t.Fatal(err)
+		}
+		if cw.writes != 1 {
+			t.Fatalf("%v: %d writes for one frame, want 1", mode, cw.writes)
+		}
+	}
+}
+
+func TestWriteFrameNewlineInPayload(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewWriter(&buf, Newline, 0).WriteFrame([]byte("two\nlines"))
+	if !errors.Is(err, ErrNewlineInPayload) {
+		t.Fatalf("err = %v, want ErrNewlineInPayload", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("wrote %q for a refused frame", buf.Bytes())
+	}
+}
+
+func TestModeFlag(t *testing.T) {
+	for _, want := range []Mode{LengthPrefixed, Newline} {
+		var m Mode
+		if err := m.Set(want.String()); err != nil || m != want {
+			t.Fatalf("Set(%q) = %v, %v", want.String(), m, err)
+		}
+	}
+	var m Mode
+	if err := m.Set("bogus"); err == nil {
+		t.Fatal("Set accepted an unknown mode")
+	}
+}
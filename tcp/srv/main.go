@@ -1,75 +1,491 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"math/rand"
 	"net"
+	"os"
+	"os/signal"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	conc "ardan/conc_patterns"
+	"ardan/tcp/frame"
+)
+
+var (
+	rate     = flag.Float64("rate", 5, "requests per second allowed per client IP")
+	burst    = flag.Int("burst", 10, "requests a client IP may burst above -rate")
+	maxFrame = flag.Int("max-frame", frame.DefaultMaxSize, "largest request accepted, in bytes")
+	idle     = flag.Duration("idle-timeout", 30*time.Second, "close connections quiet for this long, 0 for never")
+	maxReqs  = flag.Int("max-requests", 100, "requests served per connection before closing it, 0 for no limit")
+	framing  = frame.LengthPrefixed
+
+	workers      = flag.Int("workers", runtime.NumCPU(), "connections served at once")
+	queueSize    = flag.Int("queue", 64, "accepted connections waiting for a worker")
+	overload     = flag.String("overload", "reject", "what to do with connections when the queue is full: reject, queue or drop")
+	queueTimeout = flag.Duration("queue-timeout", time.Second, "how long the queue policy waits for room before rejecting")
+	statsEvery   = flag.Duration("stats-interval", 30*time.Second, "how often to log connection counts, 0 for never")
+	drain        = flag.Duration("drain", 10*time.Second, "how long shutdown waits for connections to finish before closing them")
+	logFormat    = flag.String("log-format", "text", "log output, text or json")
 )
 
+func init() {
+	flag.Var(&framing, "framing", "message framing, length or line (must match the client)")
+}
+
 // from proj root -- go run tcp/srv/main.go
 func main() {
+	flag.Parse()
+
+	logger, err := newLogger(*logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	// one token bucket per client IP, forgotten after a minute of silence
+	limiter := conc.NewKeyedLimiter[string](time.Minute, func() conc.Limiter {
+		return conc.NewTokenBucket(*rate, *burst, conc.LimiterOptions{})
+	}, conc.LimiterOptions{})
+
 	listener, err := net.Listen("tcp", "127.0.0.1:8080")
 	if err != nil {
-		log.Fatalf("Error listening: %s", err.Error())
+		fatal("listen failed", err)
 	}
 	defer listener.Close()
-	log.Printf("Server listening on 127.0.0.1:8080")
+	slog.Info("server listening", "addr", listener.Addr().String())
 
-	errs := make(chan error)
-	maxWorkers := runtime.NumCPU()
-	workers := make(chan net.Conn, maxWorkers)
-	var wg sync.WaitGroup
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	// closing the listener is what gets the accept loop out
 	go func() {
-		for err := range errs {
-			fmt.Printf("%v", err)
-		}
+		<-ctx.Done()
+		listener.Close()
 	}()
 
-	for range maxWorkers {
+	var rejects sync.WaitGroup
+	var st stats
+	var conns connSet
+	var lastID atomic.Uint64
+
+	cfg := connConfig{
+		framing:  framing,
+		maxFrame: *maxFrame,
+		idle:     *idle,
+		maxReqs:  *maxReqs,
+		// every request costs its client IP a token, however many
+		// connections it's spread over
+		allow: func(c *clientConn) bool {
+			if limiter.Allow(clientIP(c)) {
+				return true
+			}
+			st.limited.Add(1)
+			return false
+		},
+	}
+
+	// rejects are waited for too, so their log lines make it out before exit
+	reject := func(c *clientConn, reason, msg string) {
+		rejects.Add(1)
+		go func() {
+			defer rejects.Done()
+			rejectClient(c, cfg, reason, msg)
+		}()
+	}
+
+	queue, err := newConnQueue(*overload, *queueSize, *queueTimeout, reject)
+	if err != nil {
+		fatal("bad queue config", err)
+	}
+
+	served := make(chan struct{})
+	var wg sync.WaitGroup
+	for range *workers {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for conn := range workers {
-				handleClient(conn, errs)
+			for {
+				c, err := queue.Pop(context.Background())
+				if err != nil {
+					return
+				}
+				if !conns.add(c) {
+					c.finish(0, "shutdown", nil)
+					continue
+				}
+				st.active.Add(1)
+				handleClient(ctx, c, cfg)
+				st.active.Add(-1)
+				st.served.Add(1)
+				conns.remove(c)
 			}
 		}()
 	}
 
 	go func() {
 		wg.Wait()
-		close(errs)
+		close(served)
 	}()
 
+	if *statsEvery > 0 {
+		go func() {
+			for range time.Tick(*statsEvery) {
+				st.log(queue)
+			}
+		}()
+	}
+
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
 		if err != nil {
-			log.Fatalf("Error accepting connection: %s", err.Error())
+			// e.g. out of fds, back off rather than spin
+			slog.Error("accept failed", "err", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		c := newClientConn(conn, lastID.Add(1))
+		// blocks the accept loop for up to -queue-timeout under the queue
+		// policy, which leaves new clients waiting in the listen backlog
+		queue.Push(c)
+	}
+
+	// a second signal kills the process the usual way
+	stop()
+	slog.Info("shutting down", "drain", *drain)
+	queue.Close()
+
+	drained := true
+	select {
+	case <-served:
+	case <-time.After(*drain):
+		drained = false
+		slog.Warn("drain deadline passed, closing connections", "open", conns.closeAll())
+		<-served
+	}
+
+	rejects.Wait()
+	st.log(queue)
+	if !drained {
+		os.Exit(1)
+	}
+}
+
+// stats counts connections, and requests turned away by the rate limit, for
+// the periodic log line. Queued and rejected come from the queue's own
+// counters.
+type stats struct {
+	active  atomic.Int64
+	served  atomic.Uint64
+	limited atomic.Uint64
+}
+
+func (s *stats) log(queue *conc.DropQueue[*clientConn]) {
+	qs := queue.Stats()
+	slog.Info("connections",
+		"queued", qs.Len,
+		"active", s.active.Load(),
+		"served", s.served.Load(),
+		"rejected", qs.Dropped,
+		"limited", s.limited.Load())
+}
+
+// newConnQueue builds the accept queue for an -overload policy:
+//
+//	reject  full queue: tell the client the server's busy and hang up
+//	queue   wait up to timeout for room, then reject
+//	drop    full queue: hang up without a word
+func newConnQueue(policy string, size int, timeout time.Duration, reject func(c *clientConn, reason, msg string)) (*conc.DropQueue[*clientConn], error) {
+	cfg := conc.DropQueueConfig[*clientConn]{
+		Capacity: size,
+		Policy:   conc.DropNewest,
+		OnDrop: func(c *clientConn) {
+			reject(c, "busy", "Server busy, client..")
+		},
+	}
+
+	switch policy {
+	case "reject":
+	case "queue":
+		cfg.Policy = conc.BlockWithTimeout
+		cfg.Timeout = timeout
+	case "drop":
+		cfg.OnDrop = func(c *clientConn) {
+			c.Close()
+			c.finish(0, "dropped", nil)
 		}
-		go handleClient(conn, errs)
+	default:
+		return nil, fmt.Errorf("unknown overload policy %q, want reject, queue or drop", policy)
+	}
+
+	return conc.NewDropQueue(cfg), nil
+}
+
+// connSet tracks the connections being served, so shutdown can force them
+// closed once the drain deadline passes.
+type connSet struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// add tracks conn. After closeAll it closes conn instead and returns false.
+func (s *connSet) add(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		conn.Close()
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *connSet) remove(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// closeAll closes every tracked conn, and any added later, returning how many
+// it closed.
+func (s *connSet) closeAll() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
 	}
+	return len(s.conns)
 }
 
-func handleClient(conn net.Conn, errs chan error) {
-	defer conn.Close() // remove and add msg delineation between srv and cli
+// connConfig is how connections get served, as set by the flags.
+type connConfig struct {
+	framing  frame.Mode
+	maxFrame int
+	idle     time.Duration // 0 for no idle timeout
+	maxReqs  int           // 0 for no limit
+
+	// allow reports whether c may have another request served
+	allow func(c *clientConn) bool
+}
+
+// handleClient serves c, then closes it and logs how it went.
+func handleClient(ctx context.Context, c *clientConn, cfg connConfig) {
+	n, reason, err := serveConn(ctx, c, cfg)
+	c.Close()
+	c.finish(n, reason, err)
+}
+
+// serveConn serves framed requests off c until the client closes its side,
+// goes quiet for cfg.idle or has sent cfg.maxReqs of them. Requests cfg.allow
+// turns down get a back off reply instead of a response. Once ctx is done it
+// finishes the request in hand and hangs up. It returns how many requests it
+// read, why it stopped and, if the conn failed, the error.
+func serveConn(ctx context.Context, c *clientConn, cfg connConfig) (n int, reason string, err error) {
+	// wake up a read waiting on an idle client when shutdown starts
+	stopWake := context.AfterFunc(ctx, func() { c.SetReadDeadline(time.Now()) })
+	defer stopWake()
+
+	fr := frame.NewReader(c, cfg.framing, cfg.maxFrame)
+	fw := frame.NewWriter(c, cfg.framing, cfg.maxFrame)
+
+	for ; cfg.maxReqs <= 0 || n < cfg.maxReqs; n++ {
+		if cfg.idle > 0 {
+			c.SetReadDeadline(time.Now().Add(cfg.idle))
+		}
+		// checked after setting the deadline so it can't undo the wake up
+		if ctx.Err() != nil {
+			reason = "shutdown"
+			break
+		}
+
+		var req []byte
+		req, err = fr.ReadFrame()
+		var netErr net.Error
+		switch {
+		case errors.Is(err, io.EOF):
+			// client's done sending and has had all its responses
+			return n, "client done", nil
+		case errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() != nil:
+			closeWrite(c)
+			return n, "shutdown", nil
+		case errors.As(err, &netErr) && netErr.Timeout():
+			closeWrite(c)
+			return n, "idle", nil
+		case err != nil:
+			return n, "", err
+		}
 
-	buf := make([]byte, 1024)
-	if _, err := conn.Read(buf); err != nil {
-		errs <- err
+		c.log.Info("request", "body", string(req))
+
+		if !cfg.allow(c) {
+			c.log.Info("request rate limited")
+			if err = fw.WriteFrame([]byte("Too many requests, client..")); err != nil {
+				return n, "", err
+			}
+			continue
+		}
+
+		// This is where I could use a pipe operator. Assuming type conv is a func,
+		// randResp() |> []byte |> WriteFrame
+		if err = fw.WriteFrame([]byte(randResp())); err != nil {
+			return n, "", err
+		}
+	}
+
+	if reason == "" {
+		reason = "max requests"
+	}
+	closeWrite(c)
+	return n, reason, nil
+}
+
+// closeWrite half-closes conn so the client reads EOF after the last response,
+// then drains whatever it was still sending. Closing with unread data would
+// reset the conn, and the reset can wipe out responses the client hasn't read.
+func closeWrite(conn net.Conn) {
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
 		return
 	}
+	if err := cw.CloseWrite(); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	io.Copy(io.Discard, conn)
+}
 
-	log.Printf("Received request: %s", string(buf))
+// rejectClient sends msg telling the client to back off and hangs up.
+func rejectClient(c *clientConn, cfg connConfig, reason, msg string) {
+	err := frame.NewWriter(c, cfg.framing, cfg.maxFrame).WriteFrame([]byte(msg))
+	c.Close()
+	c.finish(0, reason, err)
+}
 
-	// This is where I could use a pipe operator. Assuming type conv is a func,
-	// randResp() |> []byte |> conn.Write
-	if _, err := conn.Write([]byte(randResp())); err != nil {
-		errs <- err
+// clientConn is a conn with an ID, its own logger and counts of the bytes
+// going each way.
+type clientConn struct {
+	net.Conn
+	id    uint64
+	log   *slog.Logger
+	start time.Time
+
+	// only touched by the goroutine serving the conn
+	in, out int64
+}
+
+func newClientConn(conn net.Conn, id uint64) *clientConn {
+	return &clientConn{
+		Conn:  conn,
+		id:    id,
+		log:   slog.With("conn", id, "remote", conn.RemoteAddr().String()),
+		start: time.Now(),
+	}
+}
+
+func (c *clientConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in += int64(n)
+	return n, err
+}
+
+func (c *clientConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out += int64(n)
+	return n, err
+}
+
+// CloseWrite passes through to the conn underneath, for closeWrite.
+func (c *clientConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// finish logs the end of the conn, at Warn with the error's kind if it failed.
+// reason may be empty when err says it all.
+func (c *clientConn) finish(requests int, reason string, err error) {
+	attrs := []any{
+		"requests", requests,
+		"bytes_in", c.in,
+		"bytes_out", c.out,
+		"duration", time.Since(c.start),
+	}
+	if reason != "" {
+		attrs = append(attrs, "reason", reason)
+	}
+	if err != nil {
+		c.log.Warn("connection failed", append(attrs, "kind", errKind(err), "err", err)...)
 		return
 	}
+	c.log.Info("connection closed", attrs...)
+}
+
+// errKind sorts connection errors into the few kinds worth alerting on
+// differently.
+func errKind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, syscall.ECONNABORTED):
+		return "reset"
+	case errors.Is(err, frame.ErrTooLarge), errors.Is(err, frame.ErrNewlineInPayload):
+		return "protocol"
+	case errors.Is(err, net.ErrClosed):
+		// force closed by shutdown
+		return "closed"
+	default:
+		return "other"
+	}
+}
+
+func newLogger(format string) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, want text or json", format)
+	}
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+func clientIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
 }
 
 func randResp() string {
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"ardan/tcp/frame"
+)
+
+// served is what serveConn returned.
+type served struct {
+	n      int
+	reason string
+	err    error
+}
+
+func quietConn(conn net.Conn, id uint64) *clientConn {
+	c := newClientConn(conn, id)
+	c.log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return c
+}
+
+func testConfig() connConfig {
+	return connConfig{
+		framing:  frame.LengthPrefixed,
+		maxFrame: 1024,
+		allow:    func(*clientConn) bool { return true },
+	}
+}
+
+// serve runs serveConn on the server end of a loopback conn. It returns the
+// client end, and where serveConn's result comes out.
+func serve(t *testing.T, ctx context.Context, cfg connConfig) (*net.TCPConn, <-chan served) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	conn, ok := <-accepted
+	if !ok {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	result := make(chan served, 1)
+	go func() {
+		n, reason, err := serveConn(ctx, quietConn(conn, 1), cfg)
+		result <- served{n, reason, err}
+	}()
+	return client.(*net.TCPConn), result
+}
+
+func wait(t *testing.T, result <-chan served) served {
+	t.Helper()
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveConn didn't return")
+		return served{}
+	}
+}
+
+func isResponse(b []byte) bool {
+	s := string(b)
+	return s == "Not too bad, client.." || s == "Not so great, client.."
+}
+
+func TestServeConnManyRequests(t *testing.T) {
+	client, result := serve(t, context.Background(), testConfig())
+	fw := frame.NewWriter(client, frame.LengthPrefixed, 0)
+	fr := frame.NewReader(client, frame.LengthPrefixed, 0)
+
+	for range 3 {
+		if err := fw.WriteFrame([]byte("How are you?")); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := fr.ReadFrame()
+		if err != nil || !isResponse(resp) {
+			t.Fatalf("response = %q, %v", resp, err)
+		}
+	}
+
+	// the client's done: the server sees EOF and hangs up cleanly
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	r := wait(t, result)
+	if r.n != 3 || r.reason != "client done" || r.err != nil {
+		t.Fatalf("serveConn = %+v, want 3 requests and client done", r)
+	}
+}
+
+func TestServeConnMaxRequests(t *testing.T) {
+	cfg := testConfig()
+	cfg.maxReqs = 2
+	client, result := serve(t, context.Background(), cfg)
+	fw := frame.NewWriter(client, frame.LengthPrefixed, 0)
+	fr := frame.NewReader(client, frame.LengthPrefixed, 0)
+
+	// one more than the limit, sent in one go
+	for range 3 {
+		fw.WriteFrame([]byte("again"))
+	}
+
+	for range 2 {
+		if resp, err := fr.ReadFrame(); err != nil || !isResponse(resp) {
+			t.Fatalf("response = %q, %v", resp, err)
+		}
+	}
+	// the half-close gets the client EOF after the last response
+	if _, err := fr.ReadFrame(); !errors.Is(err, io.EOF) {
+		t.Fatalf("after max requests: %v, want io.EOF", err)
+	}
+	client.Close()
+
+	r := wait(t, result)
+	if r.n != 2 || r.reason != "max requests" || r.err != nil {
+		t.Fatalf("serveConn = %+v, want 2 requests and max requests", r)
+	}
+}
+
+func TestServeConnIdle(t *testing.T) {
+	cfg := testConfig()
+	cfg.idle = 50 * time.Millisecond
+	client, result := serve(t, context.Background(), cfg)
+	fw := frame.NewWriter(client, frame.LengthPrefixed, 0)
+	fr := frame.NewReader(client, frame.LengthPrefixed, 0)
+
+	// each request puts the idle timeout off again
+	for range 3 {
+		time.Sleep(30 * time.Millisecond)
+		fw.WriteFrame([]byte("still here"))
+		if resp, err := fr.ReadFrame(); err != nil || !isResponse(resp) {
+			t.Fatalf("response = %q, %v", resp, err)
+		}
+	}
+
+	start := time.Now()
+	if _, err := fr.ReadFrame(); !errors.Is(err, io.EOF) {
+		t.Fatalf("idle client read %v, want io.EOF", err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("hung up after %v idle, before the timeout", d)
+	}
+	client.Close()
+
+	r := wait(t, result)
+	if r.n != 3 || r.reason != "idle" || r.err != nil {
+		t.Fatalf("serveConn = %+v, want 3 requests and idle", r)
+	}
+}
+
+func TestServeConnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// no idle timeout: only the shutdown can get the read to give up
+	client, result := serve(t, ctx, testConfig())
+	fw := frame.NewWriter(client, frame.LengthPrefixed, 0)
+	fr := frame.NewReader(client, frame.LengthPrefixed, 0)
+
+	fw.WriteFrame([]byte("hello"))
+	if resp, err := fr.ReadFrame(); err != nil || !isResponse(resp) {
+		t.Fatalf("response = %q, %v", resp, err)
+	}
+
+	cancel()
+	if _, err := fr.ReadFrame(); !errors.Is(err, io.EOF) {
+		t.Fatalf("read during shutdown: %v, want io.EOF", err)
+	}
+	client.Close()
+
+	r := wait(t, result)
+	if r.n != 1 || r.reason != "shutdown" || r.err != nil {
+		t.Fatalf("serveConn = %+v, want 1 request and shutdown", r)
+	}
+}
+
+func TestServeConnRateLimited(t *testing.T) {
+	cfg := testConfig()
+	calls := 0
+	cfg.allow = func(*clientConn) bool {
+		calls++
+		return calls == 1
+	}
+	client, result := serve(t, context.Background(), cfg)
+	fw := frame.NewWriter(client, frame.LengthPrefixed, 0)
+	fr := frame.NewReader(client, frame.LengthPrefixed, 0)
+
+	for i := range 3 {
+		fw.WriteFrame([]byte("hi"))
+		resp, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// turned down requests get told so, on the same conn
+		if limited := string(resp) == "Too many requests, client.."; limited != (i > 0) {
+			t.Fatalf("request %d got %q", i, resp)
+		}
+	}
+
+	client.CloseWrite()
+	r := wait(t, result)
+	if r.n != 3 || r.reason != "client done" {
+		t.Fatalf("serveConn = %+v, want 3 requests and client done", r)
+	}
+}
+
+func TestServeConnTooLarge(t *testing.T) {
+	cfg := testConfig()
+	cfg.maxFrame = 8
+	client, result := serve(t, context.Background(), cfg)
+
+	frame.NewWriter(client, frame.LengthPrefixed, 0).WriteFrame([]byte("9 bytes!!"))
+
+	r := wait(t, result)
+	if !errors.Is(r.err, frame.ErrTooLarge) || r.n != 0 {
+		t.Fatalf("serveConn = %+v, want ErrTooLarge", r)
+	}
+}
+
+// pipeConn is a clientConn over one end of a net.Pipe, and the other end.
+func pipeConn(t *testing.T, id uint64) (*clientConn, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return quietConn(server, id), client
+}
+
+type rejection struct {
+	id          uint64
+	reason, msg string
+}
+
+func TestConnQueuePolicies(t *testing.T) {
+	t.Run("reject", func(t *testing.T) {
+		var got []rejection
+		q, err := newConnQueue("reject", 1, time.Second, func(c *clientConn, reason, msg string) {
+			got = append(got, rejection{c.id, reason, msg})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c1, _ := pipeConn(t, 1)
+		c2, _ := pipeConn(t, 2)
+		if !q.Push(c1) || q.Push(c2) {
+			t.Fatal("want the first conn queued and the second turned away")
+		}
+		if len(got) != 1 || got[0] != (rejection{2, "busy", "Server busy, client.."}) {
+			t.Fatalf("rejections = %+v, want conn 2 told the server's busy", got)
+		}
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		rejected := make(chan rejection, 2)
+		q, err := newConnQueue("queue", 1, 50*time.Millisecond, func(c *clientConn, reason, msg string) {
+			rejected <- rejection{c.id, reason, msg}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c1, _ := pipeConn(t, 1)
+		c2, _ := pipeConn(t, 2)
+		c3, _ := pipeConn(t, 3)
+		q.Push(c1)
+
+		// room freed up within the timeout lets the waiting conn in
+		pushed := make(chan bool)
+		go func() { pushed <- q.Push(c2) }()
+		time.Sleep(10 * time.Millisecond)
+		if c, err := q.Pop(context.Background()); err != nil || c != c1 {
+			t.Fatalf("Pop = %v, %v", c, err)
+		}
+		if !<-pushed {
+			t.Fatal("conn waiting for room wasn't queued once there was some")
+		}
+
+		// and none does the reject after the timeout
+		start := time.Now()
+		if q.Push(c3) {
+			t.Fatal("conn queued on a full queue")
+		}
+		if d := time.Since(start); d < 40*time.Millisecond {
+			t.Fatalf("gave up after %v, before the timeout", d)
+		}
+		if r := <-rejected; r != (rejection{3, "busy", "Server busy, client.."}) {
+			t.Fatalf("rejection = %+v, want conn 3 told the server's busy", r)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		q, err := newConnQueue("drop", 1, time.Second, func(*clientConn, string, string) {
+			t.Error("dropped conn was sent a reject")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c1, _ := pipeConn(t, 1)
+		c2, peer := pipeConn(t, 2)
+		q.Push(c1)
+		if q.Push(c2) {
+			t.Fatal("conn queued on a full queue")
+		}
+
+		// hung up without a word
+		if n, err := peer.Read(make([]byte, 1)); n != 0 || !errors.Is(err, io.EOF) {
+			t.Fatalf("dropped conn read %d, %v; want io.EOF", n, err)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := newConnQueue("shrug", 1, time.Second, nil); err == nil {
+			t.Fatal("unknown policy accepted")
+		}
+	})
+}
+
+func TestErrKind(t *testing.T) {
+	op := func(err error) error { return &net.OpError{Op: "read", Net: "tcp", Err: err} }
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{io.EOF, "eof"},
+		{fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), "eof"},
+		{op(os.ErrDeadlineExceeded), "timeout"},
+		{op(os.NewSyscallError("read", syscall.ECONNRESET)), "reset"},
+		{op(os.NewSyscallError("write", syscall.EPIPE)), "reset"},
+		{op(syscall.ECONNABORTED), "reset"},
+		{fmt.Errorf("%w: 70000 > 65536 bytes", frame.ErrTooLarge), "protocol"},
+		{frame.ErrNewlineInPayload, "protocol"},
+		{op(net.ErrClosed), "closed"},
+		{errors.New("something else"), "other"},
+	}
+
+	for _, tt := range tests {
+		if got := errKind(tt.err); got != tt.want {
+			t.Errorf("errKind(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFinishLogsKind(t *testing.T) {
+	var buf bytes.Buffer
+	c, _ := pipeConn(t, 7)
+	c.log = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	c.finish(2, "", fmt.Errorf("%w: too big", frame.ErrTooLarge))
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q: %v", buf.String(), err)
+	}
+	if line["level"] != "WARN" || line["msg"] != "connection failed" || line["kind"] != "protocol" || line["requests"] != 2.0 {
+		t.Fatalf("logged %v", line)
+	}
+}